@@ -1,11 +1,17 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/PaulBernier/chockagent/audit"
 	"github.com/PaulBernier/chockagent/common"
 	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/faultproxy"
+	"github.com/PaulBernier/chockagent/journal"
 	"github.com/PaulBernier/chockagent/loadgen"
 	_log "github.com/PaulBernier/chockagent/log"
 	"github.com/mitchellh/mapstructure"
@@ -19,33 +25,92 @@ var (
 
 type Agent struct {
 	Name          string
+	factomd       *factomd.Cluster
 	wscli         *websocket.Client
+	wscliDone     <-chan struct{}
 	loadGenerator *loadgen.LoadGenerator
+	faultProxies  []*faultproxy.Proxy
+	// Health of the primary node
+	health           *healthMonitor
+	lastHeightUpdate time.Time
 }
 
-func NewAgent(name string) *Agent {
+func NewAgent(name string, cluster *factomd.Cluster) *Agent {
 	if name == "" {
 		log.Fatal("Agent name cannot be empty")
 	}
 
 	agent := new(Agent)
 	agent.Name = name
+	agent.factomd = cluster
 	agent.wscli = websocket.NewClient()
+	agent.health = newHealthMonitor(nodeStallTimeout)
 
 	return agent
 }
 
+// startFaultProxies routes the traffic to each factomd node,
+// probe node included, through a proxy injecting faults.
+func (a *Agent) startFaultProxies(nodes []*factomd.Client) {
+	for _, node := range nodes {
+		proxy, err := faultproxy.New(node.Endpoint)
+		if err != nil {
+			log.WithError(err).Fatal("Failed to create fault proxy")
+		}
+		proxy.SetFaults(faultproxy.InitialFaults())
+		endpoint, err := proxy.Start()
+		if err != nil {
+			log.WithError(err).Fatal("Failed to start fault proxy")
+		}
+
+		log.WithField("target", node.Endpoint).
+			WithField("endpoint", endpoint).
+			WithField("faults", fmt.Sprintf("%+v", proxy.Faults())).
+			Info("Fault proxy started")
+		node.Endpoint = endpoint
+		a.faultProxies = append(a.faultProxies, proxy)
+	}
+}
+
+func (a *Agent) closeFaultProxies() {
+	for _, proxy := range a.faultProxies {
+		proxy.Close()
+	}
+}
+
 func (a *Agent) Start(stop <-chan struct{}) <-chan struct{} {
 	done := make(chan struct{})
 
-	// Verify that the agent was not deployed along a mainnet node
-	mainnet, err := factomd.IsMainnet()
-	if err != nil {
-		log.WithError(err).Fatal("Failed to reach factomd node")
+	// Verify that the agent was deployed along nodes of an allowed network
+	var networkID factom.NetworkID
+	nodes := a.factomd.Nodes
+	if a.factomd.Probe != nil {
+		nodes = append(nodes[:len(nodes):len(nodes)], a.factomd.Probe)
+	}
+	for i, node := range nodes {
+		nodeNetworkID, err := node.NetworkID(context.Background())
+		if err != nil {
+			log.WithError(err).WithField("endpoint", node.Endpoint).Fatal("Failed to reach factomd node")
+		}
+		if i == 0 {
+			networkID = nodeNetworkID
+		} else if nodeNetworkID != networkID {
+			log.WithField("endpoint", node.Endpoint).
+				Fatalf("Factomd node is on network [%s] while primary node is on [%s]", nodeNetworkID, networkID)
+		}
+	}
+	if !factomd.IsAllowedNetwork(networkID) {
+		log.WithField("allowed-networks", factomd.AllowedNetworks()).
+			Fatalf("Chockagent cannot run against a node of network [%s]", networkID)
 	}
-	if mainnet {
-		log.Fatal("Chockagent cannot run against a Factom mainnet node")
+	log.WithField("network", networkID).Info("Factomd network verified")
+	// Only once verified, for the injected faults not to fail the verification
+	if faultproxy.Enabled() {
+		a.startFaultProxies(nodes)
 	}
+	a.wscli.Header["networkid"] = []string{networkID.String()}
+	log.WithField("version", version).Info("Agent identified")
+	a.wscli.Hello = a.helloMessage(networkID)
 
 	go func() {
 		defer close(done)
@@ -58,13 +123,23 @@ func (a *Agent) Start(stop <-chan struct{}) <-chan struct{} {
 func (a *Agent) run(stop <-chan struct{}) {
 	stopWsCli := make(chan struct{})
 	doneServer := a.wscli.Start(a.Name, stopWsCli)
-	a.sendCurrentHeight()
-	heightUpdateTicker := time.NewTicker(time.Duration(60) * time.Second)
+	a.wscliDone = doneServer
+	defer a.closeFaultProxies()
+	a.checkHealth(time.Now())
+	healthTicker := time.NewTicker(healthCheckInterval)
+	defer healthTicker.Stop()
 
 	for {
 		select {
-		case <-heightUpdateTicker.C:
-			a.sendCurrentHeight()
+		case now := <-healthTicker.C:
+			a.checkHealth(now)
+		case event, ok := <-a.loadEvents():
+			if !ok {
+				// The load is over
+				a.loadGenerator = nil
+				continue
+			}
+			a.send(event.Type, event.Payload)
 		case received, ok := <-a.wscli.Receive:
 			if !ok {
 				return
@@ -97,20 +172,21 @@ type Message struct {
 	Payload   interface{} `json:"payload"`
 }
 
-func (a *Agent) sendCurrentHeight() {
-	blockheight, _, err := factomd.CurrentBlockAndMinute()
-	if err != nil {
-		log.Warnf("Failed to send current height because of height fetching: %s", err)
-		return
-	}
+func newMessage(msgType string, payload interface{}, now time.Time) ([]byte, error) {
+	return json.Marshal(Message{Type: msgType, Timestamp: now.Unix(), Payload: payload})
+}
 
-	msg := Message{Type: "blockheight", Timestamp: time.Now().Unix(), Payload: blockheight}
-	bytes, err := json.Marshal(msg)
+func (a *Agent) send(msgType string, payload interface{}) {
+	bytes, err := newMessage(msgType, payload, time.Now())
 	if err != nil {
-		log.Warnf("Failed to send current height because of JSON marshalling: %s", err)
+		log.Warnf("Failed to send [%s] because of JSON marshalling: %s", msgType, err)
 		return
 	}
-	a.wscli.Send <- bytes
+	select {
+	case a.wscli.Send <- bytes:
+	case <-a.wscliDone:
+		log.Warnf("Failed to send [%s] because the connection is closed", msgType)
+	}
 }
 
 /**********
@@ -123,11 +199,33 @@ type Command struct {
 }
 
 type StartLoadCommand struct {
-	Type           string                 `mapstructure:"type"`
-	ChainIDs       []string               `mapstructure:"chainIds"`
-	EsAddress      string                 `mapstructure:"esAddress"`
-	EntrySizeRange common.IntRange        `mapstructure:"entrySizeRange"`
-	Params         map[string]interface{} `mapstructure:"params"`
+	Type           string          `mapstructure:"type"`
+	ChainIDs       []string        `mapstructure:"chainIds"`
+	EsAddress      string          `mapstructure:"esAddress"`
+	EntrySizeRange common.IntRange `mapstructure:"entrySizeRange"`
+	ECBalanceFloor uint64          `mapstructure:"ecBalanceFloor"`
+	// Share of the entries probed for propagation latency, 0 disabling the probes
+	PropagationSampleRate *float64 `mapstructure:"propagationSampleRate"`
+	// Deliberately invalid entries mixed with the load
+	InvalidTraffic loadgen.InvalidTrafficConfig `mapstructure:"invalidTraffic"`
+	// Previously submitted entries submitted again
+	Replay loadgen.ReplayConfig `mapstructure:"replay"`
+	// How entries are committed and revealed
+	Submit loadgen.SubmitConfig `mapstructure:"submit"`
+	// Number of concurrent submissions
+	Workers int `mapstructure:"workers"`
+	// Seed of the composed entries, to reproduce a previous load
+	Seed *int64 `mapstructure:"seed"`
+	// Identifies the load in the submission journal
+	JobID  string                 `mapstructure:"jobId"`
+	Params map[string]interface{} `mapstructure:"params"`
+}
+
+type AuditCommand struct {
+	JobID string `mapstructure:"jobId"`
+	// Override the heights recorded in the journal
+	StartHeight int `mapstructure:"startHeight"`
+	EndHeight   int `mapstructure:"endHeight"`
 }
 
 func (a *Agent) handleMessage(received []byte) {
@@ -146,6 +244,20 @@ func (a *Agent) handleMessage(received []byte) {
 		a.startLoad(slc)
 	case "stop-load":
 		a.stopLoad()
+	case "audit":
+		var ac AuditCommand
+		mapstructure.Decode(cmd.Params, &ac)
+		// Auditing a large job takes a while, do not block the agent
+		go a.audit(ac)
+	case "collect-diagnostics":
+		go a.collectDiagnostics()
+	case "set-faults":
+		var faults faultproxy.Faults
+		mapstructure.Decode(cmd.Params, &faults)
+		if err := a.setFaults(faults); err != nil {
+			log.WithError(err).Error("Failed to set faults")
+			a.send("set-faults-failed", loadgen.ReasonPayload{Reason: err.Error()})
+		}
 	default:
 		log.Warnf("Unexpected command [%s]!\n", cmd.Command)
 	}
@@ -155,25 +267,91 @@ func (a *Agent) startLoad(slc StartLoadCommand) {
 	// Stop any stale load that could be still running
 	a.stopLoad()
 
-	loadGenerator := loadgen.NewLoadGenerator()
+	loadGenerator := loadgen.NewLoadGenerator(a.factomd)
 	err := loadGenerator.Run(loadgen.LoadConfig{
-		Type:           slc.Type,
-		ChainIDsStr:    slc.ChainIDs,
-		EsAddressStr:   slc.EsAddress,
-		EntrySizeRange: slc.EntrySizeRange,
-		Params:         slc.Params,
+		Type:                  slc.Type,
+		ChainIDsStr:           slc.ChainIDs,
+		EsAddressStr:          slc.EsAddress,
+		EntrySizeRange:        slc.EntrySizeRange,
+		ECBalanceFloor:        slc.ECBalanceFloor,
+		PropagationSampleRate: slc.PropagationSampleRate,
+		InvalidTraffic:        slc.InvalidTraffic,
+		Replay:                slc.Replay,
+		Submit:                slc.Submit,
+		Workers:               slc.Workers,
+		Seed:                  slc.Seed,
+		JobID:                 slc.JobID,
+		Params:                slc.Params,
 	})
 
 	if err != nil {
 		log.WithError(err).Error("Failed to start load generator")
+		a.send("load-start-failed", loadgen.ReasonPayload{Reason: err.Error()})
 	} else {
 		a.loadGenerator = loadGenerator
+		if autoPauseLoad && !a.health.healthy() {
+			a.pauseLoadIfUnhealthy()
+		}
 	}
 }
 
 func (a *Agent) stopLoad() {
 	if a.loadGenerator != nil {
 		a.loadGenerator.Stop()
+		// Relay the events emitted while stopping
+		for event := range a.loadGenerator.Events() {
+			a.send(event.Type, event.Payload)
+		}
 		a.loadGenerator = nil
 	}
 }
+
+func (a *Agent) audit(ac AuditCommand) {
+	log.WithField("job-id", ac.JobID).Info("Audit started")
+	report, err := audit.RunJob(context.Background(), a.factomd.Primary(), journal.Dir(), ac.JobID,
+		audit.Options{StartHeight: ac.StartHeight, EndHeight: ac.EndHeight})
+	if err != nil {
+		log.WithError(err).Error("Failed to audit job")
+		a.send("audit-failed", loadgen.ReasonPayload{Reason: err.Error()})
+		return
+	}
+
+	log.WithField("job-id", report.JobID).
+		WithField("entries", report.Entries).
+		WithField("confirmed", report.Confirmed).
+		WithField("pending", report.Pending).
+		WithField("missing", report.NbMissing).
+		WithField("unexpected", report.NbUnexpected).
+		Info("Audit finished")
+	a.send("audit-report", report)
+}
+
+func (a *Agent) collectDiagnostics() {
+	payload := loadgen.CollectDiagnostics(context.Background(), a.factomd, loadgen.DiagnosticsOnCommand, "")
+	log.WithField("nodes", len(payload.Nodes)).Info("Diagnostics collected")
+	a.send("diagnostics", payload)
+}
+
+func (a *Agent) setFaults(faults faultproxy.Faults) error {
+	if len(a.faultProxies) == 0 {
+		return fmt.Errorf("Fault proxy is not enabled (see FACTOMD_FAULT_PROXY and FACTOMD_FAULTS)")
+	}
+	if err := faults.IsValid(); err != nil {
+		return fmt.Errorf("Invalid faults: %s", err)
+	}
+
+	for _, proxy := range a.faultProxies {
+		proxy.SetFaults(faults)
+	}
+	log.WithField("faults", fmt.Sprintf("%+v", faults)).Info("Faults set")
+	return nil
+}
+
+// loadEvents returns the events of the ongoing load, if any.
+// A nil channel blocks forever, which disables the select case.
+func (a *Agent) loadEvents() <-chan loadgen.Event {
+	if a.loadGenerator == nil {
+		return nil
+	}
+	return a.loadGenerator.Events()
+}
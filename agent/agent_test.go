@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/mockchockablock"
+	"github.com/PaulBernier/chockagent/mockfactomd"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	agentName    = "test-agent"
+	timeout      = 5 * time.Second
+	esAddressStr = "Es3ytEKt6R5jM9juC4ks7EgxQSX8BpRnM4WADtgFoq7j1WgbEEGW"
+	chainIDStr   = "2d98021e3cf71580102224b2fcb4c5c60595e8fdf6fd1b97c6ef63e9fb3ed635"
+)
+
+type testEnv struct {
+	agent       *Agent
+	factomd     *mockfactomd.Server
+	coordinator *mockchockablock.Server
+	stop        chan struct{}
+	done        <-chan struct{}
+	close       func()
+}
+
+func startTestEnv(t *testing.T) *testEnv {
+	env := new(testEnv)
+	env.factomd = mockfactomd.NewServer(mockfactomd.DefaultConfig())
+	factomdSrv := httptest.NewServer(env.factomd.Mux())
+	env.coordinator = mockchockablock.NewServer()
+
+	cluster, err := factomd.NewCluster([]string{factomdSrv.URL + "/v2"}, factomd.RoundRobin)
+	require.NoError(t, err)
+	env.agent = NewAgent(agentName, cluster)
+	env.agent.wscli.Endpoint = env.coordinator.URL
+
+	env.stop = make(chan struct{})
+	env.done = env.agent.Start(env.stop)
+	env.close = func() {
+		env.coordinator.Close()
+		factomdSrv.Close()
+	}
+
+	require.NoError(t, env.coordinator.WaitConnections(agentName, 1, timeout))
+	return env
+}
+
+func (env *testEnv) stopAgent(t *testing.T) {
+	close(env.stop)
+	select {
+	case <-env.done:
+	case <-time.After(timeout):
+		t.Fatal("Agent did not stop")
+	}
+}
+
+func TestAgentRunsBurstLoad(t *testing.T) {
+	require := require.New(t)
+	env := startTestEnv(t)
+	defer env.close()
+
+	msg, err := env.coordinator.WaitMessage("blockheight", timeout)
+	require.NoError(err)
+	require.Equal(agentName, msg.Agent)
+	_, err = env.coordinator.WaitMessage("heights", timeout)
+	require.NoError(err)
+
+	require.NoError(env.coordinator.Send(agentName, mockchockablock.Command{
+		Command: "start-load",
+		Params: map[string]interface{}{
+			"type":           "burst",
+			"chainIds":       []string{chainIDStr},
+			"esAddress":      esAddressStr,
+			"entrySizeRange": map[string]interface{}{"min": 100, "max": 1000},
+			"params":         map[string]interface{}{"nbEntries": 50},
+		},
+	}))
+
+	require.Eventually(func() bool { return env.factomd.Counters().Reveals == 50 }, timeout, 10*time.Millisecond)
+	_, err = env.coordinator.WaitMessage("load-report", timeout)
+	require.NoError(err)
+
+	env.stopAgent(t)
+}
+
+func TestAgentSendsHello(t *testing.T) {
+	require := require.New(t)
+	env := startTestEnv(t)
+	defer env.close()
+
+	msg, err := env.coordinator.WaitMessage("hello", timeout)
+	require.NoError(err)
+	var hello Hello
+	require.NoError(json.Unmarshal(msg.Payload, &hello))
+	require.Equal(agentName, hello.Name)
+	require.Equal(ProtocolVersion, hello.ProtocolVersion)
+	require.Equal(mockfactomd.Version, hello.FactomdVersion)
+	require.Contains(hello.LoadTypes, "burst")
+	require.Contains(hello.Commands, "start-load")
+
+	env.stopAgent(t)
+}
+
+func TestAgentReportsStartFailure(t *testing.T) {
+	require := require.New(t)
+	env := startTestEnv(t)
+	defer env.close()
+
+	require.NoError(env.coordinator.Send(agentName, mockchockablock.Command{
+		Command: "start-load",
+		Params:  map[string]interface{}{"type": "unknown"},
+	}))
+
+	_, err := env.coordinator.WaitMessage("load-start-failed", timeout)
+	require.NoError(err)
+
+	env.stopAgent(t)
+}
+
+func TestAgentCollectsDiagnostics(t *testing.T) {
+	require := require.New(t)
+	env := startTestEnv(t)
+	defer env.close()
+
+	require.NoError(env.coordinator.Send(agentName, mockchockablock.Command{Command: "collect-diagnostics"}))
+	_, err := env.coordinator.WaitMessage("diagnostics", timeout)
+	require.NoError(err)
+
+	env.stopAgent(t)
+}
+
+func TestAgentReconnects(t *testing.T) {
+	require := require.New(t)
+	env := startTestEnv(t)
+	defer env.close()
+
+	_, err := env.coordinator.WaitMessage("hello", timeout)
+	require.NoError(err)
+	require.NoError(env.coordinator.Drop(agentName))
+	require.NoError(env.coordinator.WaitConnections(agentName, 2, timeout))
+	// Sent again on reconnection
+	_, err = env.coordinator.WaitMessages("hello", 2, timeout)
+	require.NoError(err)
+
+	env.stopAgent(t)
+}
+
+func TestAgentStopsOnGracefulDisconnection(t *testing.T) {
+	require := require.New(t)
+	env := startTestEnv(t)
+	defer env.close()
+
+	require.NoError(env.coordinator.Disconnect(agentName, "bye"))
+
+	select {
+	case <-env.done:
+	case <-time.After(timeout):
+		t.Fatal("Agent did not stop")
+	}
+	require.Equal(1, env.coordinator.Connections(agentName))
+}
+
+func TestAgentReportsSetFaultsFailure(t *testing.T) {
+	require := require.New(t)
+	env := startTestEnv(t)
+	defer env.close()
+
+	// The fault proxy is not enabled
+	require.NoError(env.coordinator.Send(agentName, mockchockablock.Command{
+		Command: "set-faults",
+		Params:  map[string]interface{}{"latencyMs": 100},
+	}))
+	_, err := env.coordinator.WaitMessage("set-faults-failed", timeout)
+	require.NoError(err)
+
+	env.stopAgent(t)
+}
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	HealthOK          = "healthy"
+	HealthStalled     = "stalled"
+	HealthUnavailable = "unavailable"
+	HealthRegressed   = "regressed"
+
+	// Interval between two heights messages
+	heightUpdateInterval = 60 * time.Second
+	// Consecutive failed height fetches after which the node is unavailable
+	maxHeightFailures = 3
+)
+
+var (
+	// Variable so that tests can check the health faster
+	healthCheckInterval = 10 * time.Second
+	// Duration without a new minute after which the node is stalled
+	nodeStallTimeout = 3 * time.Minute
+	// Whether the load is paused while the node is not healthy
+	autoPauseLoad bool
+)
+
+func init() {
+	if os.Getenv("NODE_STALL_TIMEOUT") != "" {
+		timeout, err := time.ParseDuration(os.Getenv("NODE_STALL_TIMEOUT"))
+		if err != nil || timeout <= 0 {
+			log.WithError(err).Fatalf("Failed to parse NODE_STALL_TIMEOUT: [%s]",
+				os.Getenv("NODE_STALL_TIMEOUT"))
+		}
+		nodeStallTimeout = timeout
+	}
+	if os.Getenv("NODE_AUTO_PAUSE") != "" {
+		autoPause, err := strconv.ParseBool(os.Getenv("NODE_AUTO_PAUSE"))
+		if err != nil {
+			log.WithError(err).Fatalf("Failed to parse NODE_AUTO_PAUSE: [%s]",
+				os.Getenv("NODE_AUTO_PAUSE"))
+		}
+		autoPauseLoad = autoPause
+	}
+}
+
+// NodeHealth is sent to the coordinator when the health of the primary node changes.
+type NodeHealth struct {
+	Status string `json:"status"`
+	Height int    `json:"height"`
+	Minute int    `json:"minute"`
+	// Last time the height or minute moved, in seconds since epoch
+	LastProgress int64 `json:"lastProgress"`
+	// Seconds since the last progress
+	SinceProgress float64 `json:"sinceProgress"`
+	// Height before a regression
+	PreviousHeight int    `json:"previousHeight,omitempty"`
+	Failures       int    `json:"failures,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// healthMonitor tracks the progression of the height and minute of a node.
+type healthMonitor struct {
+	stallTimeout time.Duration
+	health       NodeHealth
+	lastProgress time.Time
+	// Whether a height was ever fetched
+	started bool
+}
+
+func newHealthMonitor(stallTimeout time.Duration) *healthMonitor {
+	return &healthMonitor{stallTimeout: stallTimeout, health: NodeHealth{Status: HealthOK}}
+}
+
+// update records the result of a height fetch at time now.
+// It returns the health of the node and whether its status changed.
+func (hm *healthMonitor) update(height, minute int, err error, now time.Time) (NodeHealth, bool) {
+	previous := hm.health.Status
+	h := &hm.health
+	h.PreviousHeight = 0
+
+	switch {
+	case err != nil:
+		h.Failures++
+		h.Error = err.Error()
+		if h.Failures >= maxHeightFailures {
+			h.Status = HealthUnavailable
+		}
+	case !hm.started || height > h.Height || height == h.Height && minute != h.Minute:
+		h.Failures, h.Error = 0, ""
+		h.Status = HealthOK
+		hm.started = true
+		h.Height, h.Minute = height, minute
+		hm.lastProgress = now
+	case height < h.Height:
+		h.Failures, h.Error = 0, ""
+		h.Status = HealthRegressed
+		h.PreviousHeight = h.Height
+		h.Height, h.Minute = height, minute
+		hm.lastProgress = now
+	default:
+		h.Failures, h.Error = 0, ""
+		if now.Sub(hm.lastProgress) >= hm.stallTimeout {
+			h.Status = HealthStalled
+		} else if h.Status != HealthStalled {
+			h.Status = HealthOK
+		}
+	}
+
+	if hm.started {
+		h.LastProgress = hm.lastProgress.Unix()
+		h.SinceProgress = now.Sub(hm.lastProgress).Seconds()
+	}
+	return *h, h.Status != previous
+}
+
+func (hm *healthMonitor) healthy() bool {
+	return hm.health.Status == HealthOK
+}
+
+// checkHealth fetches the height of the primary node, reports any change
+// of its health and sends its heights to the coordinator when due.
+func (a *Agent) checkHealth(now time.Time) {
+	cm, err := a.factomd.Primary().CurrentMinute(context.Background())
+	health, changed := a.health.update(cm.DBHeight+1, cm.Minute, err, now)
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch current height")
+	}
+
+	if changed {
+		entry := log.WithField("status", health.Status).
+			WithField("height", health.Height).
+			WithField("minute", health.Minute)
+		if health.Status == HealthOK {
+			entry.Info("Factomd node healthy")
+		} else {
+			entry.Warn("Factomd node unhealthy")
+		}
+		a.send("node-health", health)
+		if autoPauseLoad {
+			a.pauseLoadIfUnhealthy()
+		}
+	}
+
+	if err == nil && now.Sub(a.lastHeightUpdate) >= heightUpdateInterval {
+		a.sendHeights(cm, now)
+		a.lastHeightUpdate = now
+	}
+}
+
+// pauseLoadIfUnhealthy pauses the ongoing load while the node is not healthy,
+// submitting to a stalled node only hiding the failure in submit errors.
+func (a *Agent) pauseLoadIfUnhealthy() {
+	if a.loadGenerator == nil {
+		return
+	}
+	if a.health.healthy() {
+		a.loadGenerator.Resume()
+	} else {
+		a.loadGenerator.Pause(fmt.Sprintf("Factomd node %s", a.health.health.Status))
+	}
+}
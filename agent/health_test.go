@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthMonitor(t *testing.T) {
+	require := require.New(t)
+	hm := newHealthMonitor(time.Minute)
+	now := time.Now()
+
+	health, changed := hm.update(10, 2, nil, now)
+	require.False(changed)
+	require.Equal(HealthOK, health.Status)
+
+	// Same minute, not long enough to be stalled
+	_, changed = hm.update(10, 2, nil, now.Add(30*time.Second))
+	require.False(changed)
+	health, changed = hm.update(10, 2, nil, now.Add(time.Minute))
+	require.True(changed)
+	require.Equal(HealthStalled, health.Status)
+	require.Equal(60.0, health.SinceProgress)
+
+	health, changed = hm.update(10, 3, nil, now.Add(70*time.Second))
+	require.True(changed)
+	require.Equal(HealthOK, health.Status)
+
+	health, changed = hm.update(8, 0, nil, now.Add(80*time.Second))
+	require.True(changed)
+	require.Equal(HealthRegressed, health.Status)
+	require.Equal(10, health.PreviousHeight)
+	_, changed = hm.update(8, 0, nil, now.Add(90*time.Second))
+	require.True(changed)
+
+	for i := 1; i < maxHeightFailures; i++ {
+		_, changed = hm.update(0, 0, fmt.Errorf("connection refused"), now.Add(100*time.Second))
+		require.False(changed)
+	}
+	health, changed = hm.update(0, 0, fmt.Errorf("connection refused"), now.Add(100*time.Second))
+	require.True(changed)
+	require.Equal(HealthUnavailable, health.Status)
+	require.Equal(8, health.Height)
+	require.Equal("connection refused", health.Error)
+}
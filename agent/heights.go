@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/PaulBernier/chockagent/factomd"
+)
+
+// Heights of the primary node, for the coordinator to spot nodes
+// lagging behind the network.
+type Heights struct {
+	// Last directory block saved
+	DirectoryBlockHeight int `json:"directoryBlockHeight"`
+	// Directory block being built by the leaders
+	LeaderHeight int `json:"leaderHeight"`
+	// Last directory blocks whose entry blocks, and entries, are synced
+	EntryBlockHeight int `json:"entryBlockHeight"`
+	EntryHeight      int `json:"entryHeight"`
+	Minute           int `json:"minute"`
+	// Directory blocks the node is behind the leaders, and behind in syncing
+	// the entry blocks and entries of the saved directory blocks
+	LeaderLag     int  `json:"leaderLag"`
+	EntryBlockLag int  `json:"entryBlockLag"`
+	EntryLag      int  `json:"entryLag"`
+	Synced        bool `json:"synced"`
+	StallDetected bool `json:"stallDetected"`
+	// Node time in milliseconds since epoch, and its offset from the agent clock
+	NodeTime    int64 `json:"nodeTime"`
+	ClockOffset int64 `json:"clockOffset"`
+}
+
+func newHeights(cm factomd.CurrentMinuteResult, h factomd.HeightsResult, now time.Time) Heights {
+	nodeTime := cm.CurrentTime / int64(time.Millisecond)
+	heights := Heights{
+		DirectoryBlockHeight: h.DirectoryBlockHeight,
+		LeaderHeight:         h.LeaderHeight,
+		EntryBlockHeight:     h.EntryBlockHeight,
+		EntryHeight:          h.EntryHeight,
+		Minute:               cm.Minute,
+		// The block being built is one past the last saved one
+		LeaderLag:     h.LeaderHeight - h.DirectoryBlockHeight - 1,
+		EntryBlockLag: h.DirectoryBlockHeight - h.EntryBlockHeight,
+		EntryLag:      h.DirectoryBlockHeight - h.EntryHeight,
+		StallDetected: cm.StallDetected,
+		NodeTime:      nodeTime,
+	}
+	if heights.LeaderLag < 0 {
+		heights.LeaderLag = 0
+	}
+	if nodeTime > 0 {
+		heights.ClockOffset = nodeTime - now.UnixNano()/int64(time.Millisecond)
+	}
+	heights.Synced = heights.LeaderLag == 0 && heights.EntryBlockLag <= 0 && heights.EntryLag <= 0
+	return heights
+}
+
+// sendHeights sends the height of the primary node, as a bare number
+// for older coordinators and with the sync status of the node.
+func (a *Agent) sendHeights(cm factomd.CurrentMinuteResult, now time.Time) {
+	a.send("blockheight", cm.DBHeight+1)
+
+	h, err := a.factomd.Primary().Heights(context.Background())
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch heights")
+		return
+	}
+	heights := newHeights(cm, h, now)
+	if !heights.Synced {
+		log.WithField("leader-lag", heights.LeaderLag).
+			WithField("entry-block-lag", heights.EntryBlockLag).
+			WithField("entry-lag", heights.EntryLag).
+			Warn("Factomd node not synced")
+	}
+	a.send("heights", heights)
+}
@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHeights(t *testing.T) {
+	require := require.New(t)
+	now := time.Now()
+	cm := factomd.CurrentMinuteResult{DBHeight: 100, Minute: 4, CurrentTime: now.Add(2 * time.Second).UnixNano()}
+
+	heights := newHeights(cm, factomd.HeightsResult{
+		DirectoryBlockHeight: 100, LeaderHeight: 101, EntryBlockHeight: 100, EntryHeight: 100}, now)
+	require.True(heights.Synced)
+	require.Equal(4, heights.Minute)
+	require.EqualValues(2000, heights.ClockOffset)
+
+	heights = newHeights(cm, factomd.HeightsResult{
+		DirectoryBlockHeight: 90, LeaderHeight: 101, EntryBlockHeight: 88, EntryHeight: 80}, now)
+	require.False(heights.Synced)
+	require.Equal(10, heights.LeaderLag)
+	require.Equal(2, heights.EntryBlockLag)
+	require.Equal(10, heights.EntryLag)
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/PaulBernier/chockagent/hostmetrics"
+	"github.com/PaulBernier/chockagent/journal"
+	"github.com/PaulBernier/chockagent/loadgen"
+)
+
+const (
+	// Version of the messages and commands exchanged with the coordinator
+	ProtocolVersion = 1
+)
+
+var (
+	// Set at build time (see Makefile)
+	version = "dev"
+	// Commands handled by the agent
+	commands = []string{"start-load", "stop-load", "audit", "collect-diagnostics", "set-faults"}
+)
+
+// Hello is sent to the coordinator on each connection, for it to only
+// offer the features the agent supports.
+type Hello struct {
+	Name            string   `json:"name"`
+	Version         string   `json:"version"`
+	ProtocolVersion int      `json:"protocolVersion"`
+	LoadTypes       []string `json:"loadTypes"`
+	Commands        []string `json:"commands"`
+	NetworkID       string   `json:"networkId"`
+	// Empty if the properties of the primary node could not be fetched
+	FactomdVersion    string               `json:"factomdVersion"`
+	FactomdAPIVersion string               `json:"factomdApiVersion"`
+	Nodes             int                  `json:"nodes"`
+	Probe             bool                 `json:"probe"`
+	FaultProxy        bool                 `json:"faultProxy"`
+	Journal           bool                 `json:"journal"`
+	Host              hostmetrics.HostInfo `json:"host"`
+}
+
+func (a *Agent) newHello(networkID factom.NetworkID) Hello {
+	hello := Hello{
+		Name:            a.Name,
+		Version:         version,
+		ProtocolVersion: ProtocolVersion,
+		LoadTypes:       loadgen.LoadTypes,
+		Commands:        commands,
+		NetworkID:       networkID.String(),
+		Nodes:           len(a.factomd.Nodes),
+		Probe:           a.factomd.Probe != nil,
+		FaultProxy:      len(a.faultProxies) > 0,
+		Journal:         journal.Dir() != "",
+		Host:            hostmetrics.Info(),
+	}
+
+	properties, err := a.factomd.Primary().Properties(context.Background())
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch factomd properties")
+	} else {
+		hello.FactomdVersion = properties.FactomdVersion
+		hello.FactomdAPIVersion = properties.FactomdAPIVersion
+	}
+
+	return hello
+}
+
+// helloMessage returns the hello message, built on each connection
+// so that it shows the current version of factomd.
+func (a *Agent) helloMessage(networkID factom.NetworkID) func() []byte {
+	return func() []byte {
+		bytes, err := newMessage("hello", a.newHello(networkID), time.Now())
+		if err != nil {
+			log.WithError(err).Error("Failed to marshal hello message")
+		}
+		return bytes
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/PaulBernier/chockagent/audit"
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/journal"
+)
+
+// runAudit runs the audit subcommand and returns the exit code:
+// 1 if the audit failed, 2 if entries are missing or unexpected.
+func runAudit(args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	jobID := flags.String("job", "", "ID of the job to audit")
+	dir := flags.String("dir", journal.Dir(), "Directory of the journals")
+	files := flags.String("journal", "", "Comma separated journal files to audit, instead of -job")
+	endpoint := flags.String("endpoint", "", "Factomd endpoint (default: primary node of FACTOMD_RPC_ENDPOINT)")
+	start := flags.Int("start", 0, "Start height, overriding the one of the journal")
+	end := flags.Int("end", 0, "End height, overriding the one of the journal")
+	flags.Parse(args)
+
+	if (*jobID == "") == (*files == "") {
+		fmt.Fprintln(os.Stderr, "Exactly one of -job and -journal must be set")
+		flags.Usage()
+		return 1
+	}
+
+	var cli *factomd.Client
+	if *endpoint != "" {
+		cli = factomd.NewClient(*endpoint)
+	} else {
+		cli = factomd.NewDefaultCluster().Primary()
+	}
+
+	opts := audit.Options{StartHeight: *start, EndHeight: *end}
+	var report audit.Report
+	var err error
+	if *jobID != "" {
+		report, err = audit.RunJob(context.Background(), cli, *dir, *jobID, opts)
+	} else {
+		report, err = audit.RunFiles(context.Background(), cli, strings.Split(*files, ","), opts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit failed: %s\n", err)
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if report.NbMissing > 0 || report.NbUnexpected > 0 {
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,312 @@
+// Package audit verifies that the entries recorded in a submission journal
+// landed in their chains.
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/AdamSLevy/jsonrpc2/v14"
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/journal"
+	"golang.org/x/sync/semaphore"
+)
+
+const (
+	// Concurrent API calls to factomd
+	maxConcurrentRequests = 50
+	// Maximum number of entry hashes listed per category in a report
+	maxListedEntries = 100
+)
+
+// Options of an audit. Heights are read from the journal when 0.
+type Options struct {
+	StartHeight int
+	EndHeight   int
+}
+
+type UnexpectedEntry struct {
+	EntryHash       string `json:"entryHash"`
+	ChainID         string `json:"chainId"`
+	ExpectedChainID string `json:"expectedChainId"`
+	// Height of the entry block, 0 if not found in the walked blocks
+	Height int `json:"height"`
+}
+
+// Report of an audit. Missing and unexpected entries are listed up to
+// maxListedEntries, their counts being exact.
+type Report struct {
+	JobID       string `json:"jobId"`
+	StartHeight int    `json:"startHeight"`
+	EndHeight   int    `json:"endHeight"`
+	// Entries expected in their chains
+	Entries int `json:"entries"`
+	// Entries found in an entry block of their chain within the heights of the job
+	Confirmed int `json:"confirmed"`
+	// Entries acknowledged but not in a block yet
+	Pending      int               `json:"pending"`
+	NbMissing    int               `json:"nbMissing"`
+	Missing      []string          `json:"missing"`
+	NbUnexpected int               `json:"nbUnexpected"`
+	Unexpected   []UnexpectedEntry `json:"unexpected"`
+	EntryBlocks  int               `json:"entryBlocks"`
+	// EC paid by the accepted commits, and by the entries confirmed. Both are
+	// estimated from the costs recorded in the journal, not observed on chain.
+	ExpectedECSpent  uint64 `json:"expectedEcSpent"`
+	EstimatedECSpent uint64 `json:"estimatedEcSpent"`
+	// Entries that could not be checked because of API failures
+	Errors int `json:"errors"`
+}
+
+type expectedEntry struct {
+	chainID string
+	cost    uint8
+}
+
+type blockEntry struct {
+	chainID string
+	height  int
+}
+
+// Run audits the entries of a journal against a factomd node.
+func Run(ctx context.Context, cli *factomd.Client, records []journal.Record, opts Options) (Report, error) {
+	var report Report
+	entries := make(map[string]expectedEntry)
+	costs := make(map[string]uint8)
+	chains := make(map[string]bool)
+	for _, r := range records {
+		if report.JobID == "" {
+			report.JobID = r.JobID
+		}
+		switch r.Kind {
+		case journal.Start:
+			report.StartHeight = r.Height
+		case journal.End:
+			report.EndHeight = r.Height
+		case journal.Submission:
+			if r.Result == journal.ResultError {
+				continue
+			}
+			report.ExpectedECSpent += uint64(r.ECCost)
+			costs[r.EntryHash] = r.ECCost
+			if r.Result == journal.ResultAccepted {
+				entries[r.EntryHash] = expectedEntry{chainID: r.ChainID}
+				chains[r.ChainID] = true
+			}
+		case journal.Reveal:
+			if r.Result == journal.ResultAccepted {
+				entries[r.EntryHash] = expectedEntry{chainID: r.ChainID}
+				chains[r.ChainID] = true
+			}
+		}
+	}
+	for hash, entry := range entries {
+		entry.cost = costs[hash]
+		entries[hash] = entry
+	}
+
+	if opts.StartHeight > 0 {
+		report.StartHeight = opts.StartHeight
+	}
+	if opts.EndHeight > 0 {
+		report.EndHeight = opts.EndHeight
+	}
+	if report.EndHeight == 0 {
+		// The job did not end properly
+		height, _, err := cli.CurrentBlockAndMinute(ctx)
+		if err != nil {
+			return report, err
+		}
+		report.EndHeight = height
+	}
+	if report.StartHeight == 0 {
+		return report, fmt.Errorf("Unknown start height")
+	}
+	report.Entries = len(entries)
+
+	blocks := make(map[string]blockEntry)
+	heads := make(map[string]string)
+	for chainID := range chains {
+		head, n, err := walkChain(ctx, cli, chainID, report.StartHeight, "", blocks)
+		if err != nil {
+			return report, fmt.Errorf("Failed to walk chain %s: %s", chainID, err)
+		}
+		heads[chainID] = head
+		report.EntryBlocks += n
+	}
+
+	// Entries confirmed in a block saved after their chain was walked
+	var recheck []string
+	var mu sync.Mutex
+	sem := semaphore.NewWeighted(maxConcurrentRequests)
+	for hash, entry := range entries {
+		if err := sem.Acquire(ctx, 1); err != nil {
+			return report, err
+		}
+		go func(hash string, entry expectedEntry) {
+			defer sem.Release(1)
+			block, inBlock := blocks[hash]
+			chainID, ack, err := checkEntry(ctx, cli, hash, entry.chainID, !inBlock)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				report.Errors++
+			case inBlock:
+				report.found(hash, entry, block)
+			case chainID != "" && chainID != entry.chainID:
+				report.unexpected(UnexpectedEntry{EntryHash: hash, ChainID: chainID,
+					ExpectedChainID: entry.chainID})
+			case ack.EntryData.Status == "DBlockConfirmed":
+				recheck = append(recheck, hash)
+			case ack.IsAcknowledged():
+				report.Pending++
+			default:
+				report.NbMissing++
+				if len(report.Missing) < maxListedEntries {
+					report.Missing = append(report.Missing, hash)
+				}
+			}
+		}(hash, entry)
+	}
+	if err := sem.Acquire(ctx, maxConcurrentRequests); err != nil {
+		return report, err
+	}
+	if len(recheck) == 0 {
+		return report, nil
+	}
+
+	// Walk the blocks saved since the first walk
+	rewalked := make(map[string]bool)
+	for _, hash := range recheck {
+		chainID := entries[hash].chainID
+		if rewalked[chainID] {
+			continue
+		}
+		rewalked[chainID] = true
+		_, n, err := walkChain(ctx, cli, chainID, report.StartHeight, heads[chainID], blocks)
+		if err != nil {
+			return report, fmt.Errorf("Failed to walk chain %s: %s", chainID, err)
+		}
+		report.EntryBlocks += n
+	}
+	for _, hash := range recheck {
+		entry := entries[hash]
+		if block, ok := blocks[hash]; ok {
+			report.found(hash, entry, block)
+			continue
+		}
+		// In a block below the start height of the job
+		report.unexpected(UnexpectedEntry{EntryHash: hash, ChainID: entry.chainID,
+			ExpectedChainID: entry.chainID})
+	}
+
+	return report, nil
+}
+
+// found accounts for an entry found in an entry block.
+func (r *Report) found(hash string, entry expectedEntry, block blockEntry) {
+	if block.chainID != entry.chainID || block.height > r.EndHeight {
+		r.unexpected(UnexpectedEntry{EntryHash: hash, ChainID: block.chainID,
+			ExpectedChainID: entry.chainID, Height: block.height})
+		return
+	}
+	r.Confirmed++
+	r.EstimatedECSpent += uint64(entry.cost)
+}
+
+func (r *Report) unexpected(entry UnexpectedEntry) {
+	r.NbUnexpected++
+	if len(r.Unexpected) < maxListedEntries {
+		r.Unexpected = append(r.Unexpected, entry)
+	}
+}
+
+// walkChain collects the entries of the entry blocks of a chain from its head
+// down to startHeight, or to the block until if already walked.
+// It returns the head and the number of blocks walked.
+func walkChain(ctx context.Context, cli *factomd.Client, chainID string, startHeight int,
+	until string, blocks map[string]blockEntry) (string, int, error) {
+	head, err := cli.ChainHead(ctx, chainID)
+	if err != nil {
+		if isRPCError(err) {
+			// No entry block yet
+			return until, 0, nil
+		}
+		return until, 0, err
+	}
+
+	n := 0
+	keyMR := head.ChainHead
+	for keyMR != until {
+		eb, err := cli.EntryBlock(ctx, keyMR)
+		if err != nil {
+			return until, n, err
+		}
+		if eb.Header.DBHeight < startHeight {
+			break
+		}
+
+		n++
+		for _, entry := range eb.EntryList {
+			blocks[entry.EntryHash] = blockEntry{chainID: eb.Header.ChainID, height: eb.Header.DBHeight}
+		}
+		if eb.IsFirst() {
+			break
+		}
+		keyMR = eb.Header.PrevKeyMR
+	}
+	return head.ChainHead, n, nil
+}
+
+// checkEntry returns the chain of an entry stored by the node, if any,
+// and its acknowledgement status if requested.
+func checkEntry(ctx context.Context, cli *factomd.Client, entryHash string, chainID string,
+	withAck bool) (string, factomd.EntryAckResult, error) {
+	var ack factomd.EntryAckResult
+	entry, err := cli.Entry(ctx, entryHash)
+	if err != nil && !isRPCError(err) {
+		return "", ack, err
+	}
+	if withAck {
+		if ack, err = cli.EntryAck(ctx, entryHash, chainID); err != nil {
+			return "", ack, err
+		}
+	}
+	return entry.ChainID, ack, nil
+}
+
+// isRPCError returns true if factomd responded with an error, such as not found.
+func isRPCError(err error) bool {
+	var rpcErr jsonrpc2.Error
+	return errors.As(err, &rpcErr)
+}
+
+// RunJob audits the entries of the journal of a job stored in dir.
+func RunJob(ctx context.Context, cli *factomd.Client, dir string, jobID string, opts Options) (Report, error) {
+	if dir == "" {
+		return Report{}, fmt.Errorf("No journal directory (see JOURNAL_DIR)")
+	}
+	files, err := journal.Files(dir, jobID)
+	if err != nil {
+		return Report{}, err
+	}
+	if len(files) == 0 {
+		return Report{}, fmt.Errorf("No journal for job [%s] in %s", jobID, dir)
+	}
+
+	return RunFiles(ctx, cli, files, opts)
+}
+
+// RunFiles audits the entries of the given journal files.
+func RunFiles(ctx context.Context, cli *factomd.Client, files []string, opts Options) (Report, error) {
+	records, err := journal.Read(files...)
+	if err != nil {
+		return Report{}, err
+	}
+
+	return Run(ctx, cli, records, opts)
+}
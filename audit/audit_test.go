@@ -0,0 +1,138 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/PaulBernier/chockagent/common"
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/journal"
+	"github.com/PaulBernier/chockagent/loadgen"
+	"github.com/PaulBernier/chockagent/mockfactomd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudit(t *testing.T) {
+	require := require.New(t)
+	config := mockfactomd.DefaultConfig()
+	config.MinuteDuration = 10 * time.Millisecond
+	srv := httptest.NewServer(mockfactomd.NewServer(config).Handler())
+	defer srv.Close()
+	cli := factomd.NewClient(srv.URL)
+	ctx := context.Background()
+
+	records := submitEntries(t, cli, 5)
+	waitConfirmed(t, cli, records)
+	// Accepted according to the journal but never submitted
+	missing := strings.Repeat("ab", 32)
+	records = append(records, journal.Record{Kind: journal.Submission, JobID: "job",
+		EntryHash: missing, ChainID: chainIDStr, ECCost: 1, Result: journal.ResultAccepted})
+	height, _, err := cli.CurrentBlockAndMinute(ctx)
+	require.NoError(err)
+	records = append(records, journal.Record{Kind: journal.End, JobID: "job", Height: height})
+
+	report, err := Run(ctx, cli, records, Options{})
+	require.NoError(err)
+
+	require.Equal("job", report.JobID)
+	require.Equal(6, report.Entries)
+	require.Equal(5, report.Confirmed)
+	require.Equal(1, report.NbMissing)
+	require.Equal([]string{missing}, report.Missing)
+	require.Zero(report.NbUnexpected)
+	require.Zero(report.Errors)
+	require.Equal(report.ExpectedECSpent-1, report.EstimatedECSpent)
+}
+
+func TestAuditBlockSavedAfterWalk(t *testing.T) {
+	require := require.New(t)
+	config := mockfactomd.DefaultConfig()
+	config.MinuteDuration = 10 * time.Millisecond
+	handler := mockfactomd.NewServer(config).Handler()
+	// The chain has no entry block yet when first walked
+	var walked int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		json.Unmarshal(body, &req)
+		if req.Method == "chain-head" && atomic.AddInt32(&walked, 1) == 1 {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":-32009,"message":"Missing Chain Head"}}`, req.ID)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	cli := factomd.NewClient(srv.URL)
+	ctx := context.Background()
+
+	records := submitEntries(t, cli, 5)
+	waitConfirmed(t, cli, records)
+	height, _, err := cli.CurrentBlockAndMinute(ctx)
+	require.NoError(err)
+	records = append(records, journal.Record{Kind: journal.End, JobID: "job", Height: height})
+
+	report, err := Run(ctx, cli, records, Options{})
+	require.NoError(err)
+	require.Equal(5, report.Confirmed)
+	require.Zero(report.NbUnexpected)
+	require.Zero(report.Errors)
+}
+
+const (
+	esAddressStr = "Es3ytEKt6R5jM9juC4ks7EgxQSX8BpRnM4WADtgFoq7j1WgbEEGW"
+	chainIDStr   = "2d98021e3cf71580102224b2fcb4c5c60595e8fdf6fd1b97c6ef63e9fb3ed635"
+)
+
+// submitEntries submits n entries and returns their journal from the start of the job.
+func submitEntries(t *testing.T, cli *factomd.Client, n int) []journal.Record {
+	require := require.New(t)
+	ctx := context.Background()
+
+	esAddress, _ := factom.NewEsAddress(esAddressStr)
+	composer, err := loadgen.NewRandomEntryComposer([]string{chainIDStr}, esAddress, common.IntRange{Min: 100, Max: 1000})
+	require.NoError(err)
+
+	height, _, err := cli.CurrentBlockAndMinute(ctx)
+	require.NoError(err)
+	records := []journal.Record{{Kind: journal.Start, JobID: "job", Height: height}}
+	for i := 0; i < n; i++ {
+		commit, reveal, err := composer.Compose()
+		require.NoError(err)
+		require.NoError(cli.CommitAndRevealEntry(ctx, commit, reveal))
+		records = append(records, journal.Record{Kind: journal.Submission, JobID: "job",
+			EntryHash: hex.EncodeToString(commit[7:39]), ChainID: chainIDStr,
+			ECCost: commit[39], Result: journal.ResultAccepted})
+	}
+	return records
+}
+
+// waitConfirmed waits for the submitted entries of a journal to be in a block.
+func waitConfirmed(t *testing.T, cli *factomd.Client, records []journal.Record) {
+	require.Eventually(t, func() bool {
+		for _, r := range records {
+			if r.Kind != journal.Submission {
+				continue
+			}
+			ack, err := cli.EntryAck(context.Background(), r.EntryHash, r.ChainID)
+			if err != nil || ack.EntryData.Status != "DBlockConfirmed" {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
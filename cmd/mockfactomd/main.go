@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/PaulBernier/chockagent/mockfactomd"
+
+	_log "github.com/PaulBernier/chockagent/log"
+)
+
+var (
+	log = _log.GetLog()
+)
+
+func main() {
+	config := mockfactomd.DefaultConfig()
+
+	listen := flag.String("listen", "localhost:8088", "Address to listen on")
+	flag.Var(&config.NetworkID, "network", "Network ID: mainnet, testnet, localnet or a 4 byte hex encoded custom ID")
+	flag.DurationVar(&config.MinuteDuration, "minute", config.MinuteDuration, "Duration of a simulated factomd minute")
+	flag.DurationVar(&config.Latency, "latency", config.Latency, "Latency added to every request")
+	flag.DurationVar(&config.Jitter, "jitter", config.Jitter, "Random latency added on top of -latency")
+	flag.Float64Var(&config.ErrorRate, "error-rate", config.ErrorRate, "Share of the requests failing with an injected error")
+	flag.Uint64Var(&config.ECBalance, "balance", config.ECBalance, "Initial EC balance of any address")
+	flag.Parse()
+
+	srv := mockfactomd.NewServer(config)
+
+	log.WithField("listen", *listen).
+		WithField("config", config).
+		Info("Mock factomd started")
+	log.Fatal(http.ListenAndServe(*listen, srv.Mux()))
+}
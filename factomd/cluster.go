@@ -0,0 +1,195 @@
+package factomd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdamSLevy/jsonrpc2/v14"
+)
+
+// Policy selects the nodes receiving the entries of a load.
+type Policy string
+
+const (
+	// Each entry goes to the next node
+	RoundRobin Policy = "round-robin"
+	// Each entry goes to a random node
+	Random Policy = "random"
+	// Entries go to the primary node, or to the next
+	// available one while the primary is unreachable
+	Failover Policy = "failover"
+	// The commit of an entry goes to a node and its reveal to the next one
+	FanOut Policy = "fan-out"
+)
+
+const (
+	// Delay before trying the primary node again after a failover
+	failbackDelay = 30 * time.Second
+)
+
+var (
+	endpointPolicy = RoundRobin
+)
+
+func init() {
+	if os.Getenv("FACTOMD_ENDPOINT_POLICY") != "" {
+		policy := Policy(os.Getenv("FACTOMD_ENDPOINT_POLICY"))
+		if !policy.isValid() {
+			log.Fatalf("Invalid FACTOMD_ENDPOINT_POLICY: [%s]", policy)
+		}
+		endpointPolicy = policy
+	}
+}
+
+func (p Policy) isValid() bool {
+	switch p {
+	case RoundRobin, Random, Failover, FanOut:
+		return true
+	}
+	return false
+}
+
+// Cluster dispatches entries across several factomd nodes
+// according to a Policy. The first node is the primary node,
+// used for everything but the submission of entries.
+type Cluster struct {
+	Nodes  []*Client
+	Policy Policy
+	// Optional node polled to measure the propagation of the entries
+	Probe *Client
+
+	next uint64
+
+	// Failover state
+	mu         sync.Mutex
+	active     int
+	failedOver time.Time
+}
+
+func NewCluster(endpoints []string, policy Policy) (*Cluster, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("No factomd endpoint")
+	}
+	if !policy.isValid() {
+		return nil, fmt.Errorf("Invalid endpoint policy [%s]", policy)
+	}
+
+	cluster := new(Cluster)
+	cluster.Policy = policy
+	for _, endpoint := range endpoints {
+		cluster.Nodes = append(cluster.Nodes, NewClient(endpoint))
+	}
+
+	return cluster, nil
+}
+
+// NewDefaultCluster returns a cluster of the nodes configured by
+// FACTOMD_RPC_ENDPOINT using the policy set by FACTOMD_ENDPOINT_POLICY,
+// probed by the node set by FACTOMD_PROBE_ENDPOINT.
+func NewDefaultCluster() *Cluster {
+	cluster, err := NewCluster(rpcEndpoints, endpointPolicy)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to create factomd cluster")
+	}
+	if probeEndpoint != "" {
+		cluster.Probe = NewClient(probeEndpoint)
+	}
+	return cluster
+}
+
+func (c *Cluster) Primary() *Client {
+	return c.Nodes[0]
+}
+
+func (c *Cluster) CommitAndRevealEntry(ctx context.Context, commit []byte, reveal []byte) error {
+	switch c.Policy {
+	case Failover:
+		node, err := c.failover(func(node *Client) error { return node.CommitEntry(ctx, commit) })
+		if err != nil {
+			return err
+		}
+		// The reveal goes to the node of the commit even if the active node changed since
+		return node.RevealEntry(ctx, reveal)
+	case FanOut:
+		i := c.nextIndex()
+		if err := c.Nodes[i].CommitEntry(ctx, commit); err != nil {
+			return err
+		}
+		return c.Nodes[(i+1)%len(c.Nodes)].RevealEntry(ctx, reveal)
+	default:
+		return c.node().CommitAndRevealEntry(ctx, commit, reveal)
+	}
+}
+
+// CommitEntry commits an entry to a node selected by the policy.
+func (c *Cluster) CommitEntry(ctx context.Context, commit []byte) error {
+	if c.Policy == Failover {
+		_, err := c.failover(func(node *Client) error { return node.CommitEntry(ctx, commit) })
+		return err
+	}
+	return c.node().CommitEntry(ctx, commit)
+}
+
+// RevealEntry reveals an entry to a node selected by the policy.
+func (c *Cluster) RevealEntry(ctx context.Context, reveal []byte) error {
+	if c.Policy == Failover {
+		_, err := c.failover(func(node *Client) error { return node.RevealEntry(ctx, reveal) })
+		return err
+	}
+	return c.node().RevealEntry(ctx, reveal)
+}
+
+func (c *Cluster) node() *Client {
+	if c.Policy == Random {
+		return c.Nodes[rand.Intn(len(c.Nodes))]
+	}
+	return c.Nodes[c.nextIndex()]
+}
+
+func (c *Cluster) nextIndex() int {
+	return int(atomic.AddUint64(&c.next, 1) % uint64(len(c.Nodes)))
+}
+
+// failover calls f on the active node, and on the following nodes
+// as long as they are unreachable. It returns the last node called.
+func (c *Cluster) failover(f func(node *Client) error) (node *Client, err error) {
+	c.mu.Lock()
+	if c.active != 0 && time.Since(c.failedOver) > failbackDelay {
+		c.active = 0
+	}
+	active := c.active
+	c.mu.Unlock()
+
+	for i := 0; i < len(c.Nodes); i++ {
+		index := (active + i) % len(c.Nodes)
+		node = c.Nodes[index]
+		err = f(node)
+		if !isUnreachable(err) {
+			if index != active {
+				c.mu.Lock()
+				c.active = index
+				c.failedOver = time.Now()
+				c.mu.Unlock()
+				log.WithField("endpoint", node.Endpoint).Warn("Failed over to another factomd node")
+			}
+			return node, err
+		}
+	}
+
+	return node, err
+}
+
+// isUnreachable returns true if the error is not an answer of the node.
+func isUnreachable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var rpcErr jsonrpc2.Error
+	return !errors.As(err, &rpcErr)
+}
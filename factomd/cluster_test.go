@@ -0,0 +1,99 @@
+package factomd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdamSLevy/jsonrpc2/v14"
+	"github.com/stretchr/testify/require"
+)
+
+type countingNode struct {
+	*httptest.Server
+	commits uint64
+	reveals uint64
+	// Called on each commit if set
+	onCommit func()
+}
+
+func newCountingNode() *countingNode {
+	node := new(countingNode)
+	node.Server = httptest.NewServer(jsonrpc2.HTTPRequestHandler(jsonrpc2.MethodMap{
+		"commit-entry": func(ctx context.Context, params json.RawMessage) interface{} {
+			atomic.AddUint64(&node.commits, 1)
+			if node.onCommit != nil {
+				node.onCommit()
+			}
+			return struct{}{}
+		},
+		"reveal-entry": func(ctx context.Context, params json.RawMessage) interface{} {
+			atomic.AddUint64(&node.reveals, 1)
+			return struct{}{}
+		},
+	}, nil))
+	return node
+}
+
+func TestClusterFanOut(t *testing.T) {
+	require := require.New(t)
+
+	a, b := newCountingNode(), newCountingNode()
+	defer a.Close()
+	defer b.Close()
+
+	cluster, err := NewCluster([]string{a.URL, b.URL}, FanOut)
+	require.NoError(err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(cluster.CommitAndRevealEntry(context.Background(), []byte{1}, []byte{2}))
+	}
+
+	// Each node received the commits of half the entries
+	// and the reveals of the other half
+	require.EqualValues(2, a.commits)
+	require.EqualValues(2, a.reveals)
+	require.EqualValues(2, b.commits)
+	require.EqualValues(2, b.reveals)
+}
+
+func TestClusterFailover(t *testing.T) {
+	require := require.New(t)
+
+	dead := httptest.NewServer(nil)
+	dead.Close()
+	backup := newCountingNode()
+	defer backup.Close()
+
+	cluster, err := NewCluster([]string{dead.URL, backup.URL}, Failover)
+	require.NoError(err)
+
+	require.NoError(cluster.CommitAndRevealEntry(context.Background(), []byte{1}, []byte{2}))
+	require.EqualValues(1, backup.commits)
+	require.EqualValues(1, backup.reveals)
+}
+
+func TestClusterFailoverRevealsOnCommitNode(t *testing.T) {
+	require := require.New(t)
+
+	a, b := newCountingNode(), newCountingNode()
+	defer a.Close()
+	defer b.Close()
+
+	cluster, err := NewCluster([]string{a.URL, b.URL}, Failover)
+	require.NoError(err)
+	// Another submission fails over between the commit and the reveal
+	a.onCommit = func() {
+		cluster.mu.Lock()
+		cluster.active, cluster.failedOver = 1, time.Now()
+		cluster.mu.Unlock()
+	}
+
+	require.NoError(cluster.CommitAndRevealEntry(context.Background(), []byte{1}, []byte{2}))
+	require.EqualValues(1, a.commits)
+	require.EqualValues(1, a.reveals)
+	require.Zero(b.reveals)
+}
@@ -0,0 +1,119 @@
+package factomd
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+var (
+	// Methods of the debug API collected as diagnostics
+	debugMethods = []string{
+		"holding-queue",
+		"process-list",
+		"federated-servers",
+		"audit-servers",
+		"network-info",
+		"summary",
+		"configuration",
+	}
+)
+
+// Diagnostics is the state of a node at a point in time.
+type Diagnostics struct {
+	Endpoint string `json:"endpoint"`
+	// Seconds since epoch
+	Timestamp  int64             `json:"timestamp"`
+	Properties *PropertiesResult `json:"properties,omitempty"`
+	Heights    *HeightsResult    `json:"heights,omitempty"`
+	// Raw results of the debug API by method
+	Debug map[string]json.RawMessage `json:"debug"`
+	// Errors by method of the calls that failed
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// CollectDiagnostics queries the properties, heights and debug API of the node.
+// The calls that fail are listed in Errors.
+func (cli *Client) CollectDiagnostics(ctx context.Context) Diagnostics {
+	diag := Diagnostics{
+		Endpoint:  cli.Endpoint,
+		Timestamp: time.Now().Unix(),
+		Debug:     make(map[string]json.RawMessage),
+		Errors:    make(map[string]string),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	call := func(method string, request func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := request()
+			if err != nil {
+				mu.Lock()
+				diag.Errors[method] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+
+	call("properties", func() error {
+		properties, err := cli.Properties(ctx)
+		if err == nil {
+			mu.Lock()
+			diag.Properties = &properties
+			mu.Unlock()
+		}
+		return err
+	})
+	call("heights", func() error {
+		heights, err := cli.Heights(ctx)
+		if err == nil {
+			mu.Lock()
+			diag.Heights = &heights
+			mu.Unlock()
+		}
+		return err
+	})
+	for _, method := range debugMethods {
+		method := method
+		call(method, func() error {
+			result, err := cli.Debug(ctx, method)
+			if err == nil {
+				mu.Lock()
+				diag.Debug[method] = result
+				mu.Unlock()
+			}
+			return err
+		})
+	}
+	wg.Wait()
+
+	if len(diag.Errors) == 0 {
+		diag.Errors = nil
+	}
+	return diag
+}
+
+// CollectDiagnostics collects the diagnostics of all the nodes of the cluster,
+// the probe node included.
+func (c *Cluster) CollectDiagnostics(ctx context.Context) []Diagnostics {
+	nodes := c.Nodes
+	if c.Probe != nil {
+		nodes = append(nodes[:len(nodes):len(nodes)], c.Probe)
+	}
+
+	diags := make([]Diagnostics, len(nodes))
+	var wg sync.WaitGroup
+	wg.Add(len(nodes))
+	for i, node := range nodes {
+		go func(i int, node *Client) {
+			defer wg.Done()
+			diags[i] = node.CollectDiagnostics(ctx)
+		}(i, node)
+	}
+	wg.Wait()
+
+	return diags
+}
@@ -1,9 +1,15 @@
 package factomd
 
 import (
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	_log "github.com/PaulBernier/chockagent/log"
 
@@ -11,52 +17,106 @@ import (
 )
 
 var (
-	c           jsonrpc2.Client
-	log         = _log.GetLog()
-	rpcEndpoint = "http://localhost:8088/v2"
+	log           = _log.GetLog()
+	rpcEndpoints  = []string{"http://localhost:8088/v2"}
+	probeEndpoint string
+	rpcTimeout    = 10 * time.Second
 )
 
 func init() {
 	if os.Getenv("FACTOMD_RPC_ENDPOINT") != "" {
-		_, err := url.ParseRequestURI(os.Getenv("FACTOMD_RPC_ENDPOINT"))
+		rpcEndpoints = nil
+		for _, endpoint := range strings.Split(os.Getenv("FACTOMD_RPC_ENDPOINT"), ",") {
+			endpoint = strings.TrimSpace(endpoint)
+			_, err := url.ParseRequestURI(endpoint)
+			if err != nil {
+				log.WithError(err).Fatalf("Failed to parse FACTOMD_RPC_ENDPOINT: [%s]",
+					os.Getenv("FACTOMD_RPC_ENDPOINT"))
+			}
+			rpcEndpoints = append(rpcEndpoints, endpoint)
+		}
+	}
+	if os.Getenv("FACTOMD_PROBE_ENDPOINT") != "" {
+		_, err := url.ParseRequestURI(os.Getenv("FACTOMD_PROBE_ENDPOINT"))
 		if err != nil {
-			log.WithError(err).Fatalf("Failed to parse FACTOMD_RPC_ENDPOINT: [%s]",
-				os.Getenv("FACTOMD_RPC_ENDPOINT"))
+			log.WithError(err).Fatalf("Failed to parse FACTOMD_PROBE_ENDPOINT: [%s]",
+				os.Getenv("FACTOMD_PROBE_ENDPOINT"))
+		}
+		probeEndpoint = os.Getenv("FACTOMD_PROBE_ENDPOINT")
+	}
+	if os.Getenv("FACTOMD_RPC_TIMEOUT") != "" {
+		timeout, err := time.ParseDuration(os.Getenv("FACTOMD_RPC_TIMEOUT"))
+		if err != nil || timeout <= 0 {
+			log.WithError(err).Fatalf("Failed to parse FACTOMD_RPC_TIMEOUT: [%s]",
+				os.Getenv("FACTOMD_RPC_TIMEOUT"))
 		}
-		rpcEndpoint = os.Getenv("FACTOMD_RPC_ENDPOINT")
+		rpcTimeout = timeout
 	}
 }
 
+// Client of the factomd API. It is safe for concurrent use.
+type Client struct {
+	Endpoint string
+	// Timeout of every individual RPC
+	Timeout time.Duration
+
+	rpc jsonrpc2.Client
+}
+
+// NewClient returns a client of the factomd API at endpoint.
+func NewClient(endpoint string) *Client {
+	cli := new(Client)
+	cli.Endpoint = endpoint
+	cli.Timeout = rpcTimeout
+	// The load is made of many concurrent requests to the same host,
+	// keep as many connections alive as possible
+	cli.rpc.Transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        1000,
+		MaxIdleConnsPerHost: 1000,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	return cli
+}
+
+func (cli *Client) request(ctx context.Context, method string, params, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, cli.Timeout)
+	defer cancel()
+
+	return cli.rpc.Request(ctx, cli.Endpoint, method, params, result)
+}
+
 type DBlockByHeightResult struct {
 	DBlock struct {
 		Header struct {
-			NetworkID int `json:"networkid"`
+			NetworkID uint32 `json:"networkid"`
 		} `json:"header"`
 	} `json:"dblock"`
 }
 
 type CurrentMinuteResult struct {
-	DBHeight int `json:"directoryblockheight"`
-	Minute   int `json:"minute"`
+	DBHeight     int `json:"directoryblockheight"`
+	LeaderHeight int `json:"leaderheight"`
+	Minute       int `json:"minute"`
+	// Node time in nanoseconds since epoch
+	CurrentTime   int64 `json:"currenttime"`
+	StallDetected bool  `json:"stalldetected"`
 }
 
-func IsMainnet() (bool, error) {
-	var result DBlockByHeightResult
-	err := c.Request(nil, rpcEndpoint, "dblock-by-height", struct {
-		Height int `json:"height"`
-	}{Height: 0}, &result)
-
-	if err != nil {
-		return false, err
-	}
+func (cli *Client) CurrentMinute(ctx context.Context) (CurrentMinuteResult, error) {
+	var result CurrentMinuteResult
+	err := cli.request(ctx, "current-minute", nil, &result)
 
-	return result.DBlock.Header.NetworkID == 4203931042, nil
+	return result, err
 }
 
-func CurrentBlockAndMinute() (int, int, error) {
-	var result CurrentMinuteResult
-	err := c.Request(nil, rpcEndpoint, "current-minute", nil, &result)
-
+func (cli *Client) CurrentBlockAndMinute(ctx context.Context) (int, int, error) {
+	result, err := cli.CurrentMinute(ctx)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -64,18 +124,196 @@ func CurrentBlockAndMinute() (int, int, error) {
 	return result.DBHeight + 1, result.Minute, nil
 }
 
-func CommitAndRevealEntry(commit []byte, reveal []byte) error {
-	// Commit
-	err := c.Request(nil, rpcEndpoint, "commit-entry", struct {
+func (cli *Client) CommitAndRevealEntry(ctx context.Context, commit []byte, reveal []byte) error {
+	if err := cli.CommitEntry(ctx, commit); err != nil {
+		return err
+	}
+
+	return cli.RevealEntry(ctx, reveal)
+}
+
+func (cli *Client) CommitEntry(ctx context.Context, commit []byte) error {
+	return cli.request(ctx, "commit-entry", struct {
 		Message string `json:"message"`
 	}{Message: hex.EncodeToString(commit)}, nil)
+}
+
+func (cli *Client) RevealEntry(ctx context.Context, reveal []byte) error {
+	return cli.request(ctx, "reveal-entry", struct {
+		Entry string `json:"entry"`
+	}{Entry: hex.EncodeToString(reveal)}, nil)
+}
+
+type EntryCreditBalanceResult struct {
+	Balance uint64 `json:"balance"`
+}
+
+func (cli *Client) EntryCreditBalance(ctx context.Context, ecAddress string) (uint64, error) {
+	var result EntryCreditBalanceResult
+	err := cli.request(ctx, "entry-credit-balance", struct {
+		Address string `json:"address"`
+	}{Address: ecAddress}, &result)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// Reveal
-	return c.Request(nil, rpcEndpoint, "reveal-entry", struct {
-		Entry string `json:"entry"`
-	}{Entry: hex.EncodeToString(reveal)}, nil)
+	return result.Balance, nil
+}
+
+type EntryAckResult struct {
+	EntryHash string `json:"entryhash"`
+	EntryData struct {
+		Status string `json:"status"`
+	} `json:"entrydata"`
+}
+
+// IsAcknowledged returns true once the node knows about the revealed entry.
+func (r EntryAckResult) IsAcknowledged() bool {
+	return r.EntryData.Status == "TransactionACK" || r.EntryData.Status == "DBlockConfirmed"
+}
+
+func (cli *Client) EntryAck(ctx context.Context, entryHash string, chainID string) (EntryAckResult, error) {
+	var result EntryAckResult
+	err := cli.request(ctx, "entry-ack", struct {
+		TxID    string `json:"txid"`
+		ChainID string `json:"chainid"`
+	}{TxID: entryHash, ChainID: chainID}, &result)
+
+	return result, err
+}
+
+type EntryResult struct {
+	ChainID string   `json:"chainid"`
+	Content string   `json:"content"`
+	ExtIDs  []string `json:"extids"`
+}
+
+// Entry returns an entry stored in the database of the node.
+func (cli *Client) Entry(ctx context.Context, entryHash string) (EntryResult, error) {
+	var result EntryResult
+	err := cli.request(ctx, "entry", struct {
+		Hash string `json:"hash"`
+	}{Hash: entryHash}, &result)
+
+	return result, err
+}
+
+type ChainHeadResult struct {
+	ChainHead string `json:"chainhead"`
+	// The chain has entries in the current block, not yet in ChainHead
+	ChainInProcessList bool `json:"chaininprocesslist"`
+}
+
+func (cli *Client) ChainHead(ctx context.Context, chainID string) (ChainHeadResult, error) {
+	var result ChainHeadResult
+	err := cli.request(ctx, "chain-head", struct {
+		ChainID string `json:"chainid"`
+	}{ChainID: chainID}, &result)
+
+	return result, err
+}
+
+type EntryBlockResult struct {
+	Header struct {
+		BlockSequenceNumber int    `json:"blocksequencenumber"`
+		ChainID             string `json:"chainid"`
+		PrevKeyMR           string `json:"prevkeymr"`
+		Timestamp           int64  `json:"timestamp"`
+		DBHeight            int    `json:"dbheight"`
+	} `json:"header"`
+	EntryList []struct {
+		EntryHash string `json:"entryhash"`
+		Timestamp int64  `json:"timestamp"`
+	} `json:"entrylist"`
+}
+
+// IsFirst returns true for the first entry block of a chain.
+func (r EntryBlockResult) IsFirst() bool {
+	return strings.Trim(r.Header.PrevKeyMR, "0") == ""
+}
+
+func (cli *Client) EntryBlock(ctx context.Context, keyMR string) (EntryBlockResult, error) {
+	var result EntryBlockResult
+	err := cli.request(ctx, "entry-block", struct {
+		KeyMR string `json:"keymr"`
+	}{KeyMR: keyMR}, &result)
+
+	return result, err
+}
+
+func (cli *Client) DirectoryBlockHead(ctx context.Context) (string, error) {
+	var result struct {
+		KeyMR string `json:"keymr"`
+	}
+	err := cli.request(ctx, "directory-block-head", nil, &result)
+
+	return result.KeyMR, err
+}
+
+type DirectoryBlockResult struct {
+	Header struct {
+		PrevBlockKeyMR string `json:"prevblockkeymr"`
+		SequenceNumber int    `json:"sequencenumber"`
+		// Seconds since epoch
+		Timestamp int64 `json:"timestamp"`
+	} `json:"header"`
+	EntryBlockList []struct {
+		ChainID string `json:"chainid"`
+		KeyMR   string `json:"keymr"`
+	} `json:"entryblocklist"`
+}
+
+func (cli *Client) DirectoryBlock(ctx context.Context, keyMR string) (DirectoryBlockResult, error) {
+	var result DirectoryBlockResult
+	err := cli.request(ctx, "directory-block", struct {
+		KeyMR string `json:"keymr"`
+	}{KeyMR: keyMR}, &result)
+
+	return result, err
+}
+
+type PropertiesResult struct {
+	FactomdVersion    string `json:"factomdversion"`
+	FactomdAPIVersion string `json:"factomdapiversion"`
+}
+
+func (cli *Client) Properties(ctx context.Context) (PropertiesResult, error) {
+	var result PropertiesResult
+	err := cli.request(ctx, "properties", nil, &result)
+
+	return result, err
+}
+
+type HeightsResult struct {
+	// Last directory block saved
+	DirectoryBlockHeight int `json:"directoryblockheight"`
+	// Directory block being built by the leaders
+	LeaderHeight int `json:"leaderheight"`
+	// Last directory block whose entry blocks are all synced
+	EntryBlockHeight int `json:"entryblockheight"`
+	// Last directory block whose entries are all synced
+	EntryHeight int `json:"entryheight"`
+}
+
+func (cli *Client) Heights(ctx context.Context) (HeightsResult, error) {
+	var result HeightsResult
+	err := cli.request(ctx, "heights", nil, &result)
+
+	return result, err
+}
+
+// DebugEndpoint returns the endpoint of the debug API, served next to the v2 API.
+func (cli *Client) DebugEndpoint() string {
+	return strings.TrimSuffix(strings.TrimSuffix(cli.Endpoint, "/"), "/v2") + "/debug"
+}
+
+// Debug calls a method of the debug API, returning its raw result.
+func (cli *Client) Debug(ctx context.Context, method string) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, cli.Timeout)
+	defer cancel()
+
+	var result json.RawMessage
+	err := cli.rpc.Request(ctx, cli.DebugEndpoint(), method, nil, &result)
+	return result, err
 }
@@ -0,0 +1,48 @@
+package factomd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AdamSLevy/jsonrpc2/v14"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRequest(t *testing.T) {
+	require := require.New(t)
+
+	srv := httptest.NewServer(jsonrpc2.HTTPRequestHandler(jsonrpc2.MethodMap{
+		"current-minute": func(ctx context.Context, params json.RawMessage) interface{} {
+			return CurrentMinuteResult{DBHeight: 41, Minute: 3}
+		},
+	}, nil))
+	defer srv.Close()
+
+	cli := NewClient(srv.URL)
+	height, minute, err := cli.CurrentBlockAndMinute(context.Background())
+
+	require.NoError(err)
+	require.Equal(42, height)
+	require.Equal(3, minute)
+}
+
+func TestClientTimeout(t *testing.T) {
+	require := require.New(t)
+
+	srv := httptest.NewServer(jsonrpc2.HTTPRequestHandler(jsonrpc2.MethodMap{
+		"current-minute": func(ctx context.Context, params json.RawMessage) interface{} {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}, nil))
+	defer srv.Close()
+
+	cli := NewClient(srv.URL)
+	cli.Timeout = 50 * time.Millisecond
+	_, _, err := cli.CurrentBlockAndMinute(context.Background())
+
+	require.Error(err)
+}
@@ -0,0 +1,95 @@
+package factomd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Factom-Asset-Tokens/factom"
+)
+
+var (
+	// Network of factomd started with -network=TEST, used for development
+	devnetID = factom.NetworkID{0xFA, 0x92, 0xE5, 0xA3}
+	// Networks the agent is allowed to run against
+	allowedNetworks = []factom.NetworkID{factom.TestnetID(), factom.LocalnetID()}
+)
+
+func init() {
+	if os.Getenv("ALLOWED_NETWORKS") != "" {
+		networks, err := parseNetworks(os.Getenv("ALLOWED_NETWORKS"))
+		if err != nil {
+			log.WithError(err).Fatalf("Failed to parse ALLOWED_NETWORKS: [%s]",
+				os.Getenv("ALLOWED_NETWORKS"))
+		}
+		allowedNetworks = networks
+	}
+}
+
+// parseNetworks parses a comma separated list of network IDs.
+// Each network is either "testnet", "devnet", "localnet", "custom:<name>"
+// for a network started with -customnet=<name>, or a 4 byte hex encoded ID.
+func parseNetworks(list string) ([]factom.NetworkID, error) {
+	var networks []factom.NetworkID
+	for _, str := range strings.Split(list, ",") {
+		str = strings.TrimSpace(str)
+		if len(str) < 2 {
+			return nil, fmt.Errorf("Invalid network ID [%s]", str)
+		}
+
+		var networkID factom.NetworkID
+		switch {
+		case strings.ToLower(str) == "devnet":
+			networkID = devnetID
+		case strings.HasPrefix(str, "custom:") && len(str) > len("custom:"):
+			// Same derivation as factomd for custom networks
+			hash := sha256.Sum256([]byte(str[len("custom:"):]))
+			copy(networkID[:], hash[:])
+		default:
+			if err := networkID.Set(str); err != nil {
+				return nil, fmt.Errorf("Invalid network ID [%s]: %s", str, err)
+			}
+		}
+		if networkID.IsMainnet() {
+			return nil, fmt.Errorf("Mainnet cannot be allowed")
+		}
+		networks = append(networks, networkID)
+	}
+
+	return networks, nil
+}
+
+func AllowedNetworks() []factom.NetworkID {
+	return allowedNetworks
+}
+
+// IsAllowedNetwork returns true if the network is part of the allowlist.
+func IsAllowedNetwork(networkID factom.NetworkID) bool {
+	if networkID.IsMainnet() {
+		return false
+	}
+	for _, allowed := range allowedNetworks {
+		if networkID == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (cli *Client) NetworkID(ctx context.Context) (factom.NetworkID, error) {
+	var result DBlockByHeightResult
+	err := cli.request(ctx, "dblock-by-height", struct {
+		Height int `json:"height"`
+	}{Height: 0}, &result)
+
+	if err != nil {
+		return factom.NetworkID{}, err
+	}
+
+	var networkID factom.NetworkID
+	binary.BigEndian.PutUint32(networkID[:], result.DBlock.Header.NetworkID)
+	return networkID, nil
+}
@@ -0,0 +1,29 @@
+package factomd
+
+import (
+	"testing"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetworks(t *testing.T) {
+	require := require.New(t)
+
+	networks, err := parseNetworks("testnet, localnet,0xfa92e5a5")
+	require.NoError(err)
+	require.Len(networks, 3)
+	require.Equal(factom.TestnetID(), networks[0])
+	require.Equal(factom.LocalnetID(), networks[1])
+	require.Equal(factom.NetworkID{0xfa, 0x92, 0xe5, 0xa5}, networks[2])
+
+	networks, err = parseNetworks("devnet,custom:fct_community_test")
+	require.NoError(err)
+	require.Equal([]factom.NetworkID{{0xfa, 0x92, 0xe5, 0xa3}, factom.TestnetID()}, networks)
+
+	_, err = parseNetworks("testnet,mainnet")
+	require.Error(err)
+
+	_, err = parseNetworks("testnet,")
+	require.Error(err)
+}
@@ -0,0 +1,234 @@
+// Package faultproxy implements a reverse proxy degrading
+// the link between the agent and factomd.
+package faultproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	_log "github.com/PaulBernier/chockagent/log"
+)
+
+var (
+	log           = _log.GetLog()
+	enabled       bool
+	initialFaults Faults
+)
+
+func init() {
+	enabled = os.Getenv("FACTOMD_FAULT_PROXY") == "true"
+	if os.Getenv("FACTOMD_FAULTS") != "" {
+		if err := json.Unmarshal([]byte(os.Getenv("FACTOMD_FAULTS")), &initialFaults); err != nil {
+			log.WithError(err).Fatalf("Failed to parse FACTOMD_FAULTS: [%s]", os.Getenv("FACTOMD_FAULTS"))
+		}
+		if err := initialFaults.IsValid(); err != nil {
+			log.WithError(err).Fatalf("Invalid FACTOMD_FAULTS: [%s]", os.Getenv("FACTOMD_FAULTS"))
+		}
+		enabled = true
+	}
+}
+
+// Enabled returns true if the agent should proxy its factomd traffic,
+// as configured by FACTOMD_FAULT_PROXY or FACTOMD_FAULTS.
+func Enabled() bool {
+	return enabled
+}
+
+// InitialFaults returns the faults configured by FACTOMD_FAULTS.
+func InitialFaults() Faults {
+	return initialFaults
+}
+
+// Faults injected by the proxy. The zero value injects nothing.
+type Faults struct {
+	// Latency added to every request, up to LatencyMs+JitterMs
+	LatencyMs int `json:"latencyMs" mapstructure:"latencyMs"`
+	JitterMs  int `json:"jitterMs" mapstructure:"jitterMs"`
+	// Share of the requests whose connection is closed without response
+	DropRate float64 `json:"dropRate" mapstructure:"dropRate"`
+	// Share of the requests answered with a 503
+	ErrorRate float64 `json:"errorRate" mapstructure:"errorRate"`
+	// Bandwidth in bytes per second in each direction, 0 for unlimited
+	Bandwidth int `json:"bandwidth" mapstructure:"bandwidth"`
+}
+
+func (f Faults) IsValid() error {
+	if f.LatencyMs < 0 || f.JitterMs < 0 || f.Bandwidth < 0 {
+		return fmt.Errorf("Negative latency, jitter or bandwidth")
+	}
+	if f.DropRate < 0 || f.DropRate > 1 || f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return fmt.Errorf("Rates must be between 0 and 1")
+	}
+	return nil
+}
+
+// Proxy forwards the requests to a factomd node, injecting faults.
+type Proxy struct {
+	// Endpoint proxied
+	Target string
+
+	reverseProxy *httputil.ReverseProxy
+	targetPath   string
+	server       *http.Server
+
+	mu     sync.Mutex
+	faults Faults
+	rand   *rand.Rand
+	// Bandwidth shared by all the requests, in each direction
+	upload   link
+	download link
+}
+
+func New(target string) (*Proxy, error) {
+	targetURL, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, err
+	}
+
+	p := new(Proxy)
+	p.Target = target
+	p.targetPath = targetURL.Path
+	p.reverseProxy = httputil.NewSingleHostReverseProxy(&url.URL{Scheme: targetURL.Scheme, Host: targetURL.Host})
+	p.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	return p, nil
+}
+
+// Start listens on a local port and returns the endpoint
+// to use instead of the target.
+func (p *Proxy) Start() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	p.server = &http.Server{Handler: p}
+	go func() {
+		if err := p.server.Serve(listener); err != http.ErrServerClosed {
+			log.WithError(err).Error("Fault proxy stopped unexpectedly")
+		}
+	}()
+
+	return "http://" + listener.Addr().String() + p.targetPath, nil
+}
+
+func (p *Proxy) Close() error {
+	if p.server == nil {
+		return nil
+	}
+	return p.server.Close()
+}
+
+func (p *Proxy) SetFaults(faults Faults) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.faults = faults
+}
+
+func (p *Proxy) Faults() Faults {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.faults
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	faults := p.faults
+	latency := time.Duration(faults.LatencyMs) * time.Millisecond
+	if faults.JitterMs > 0 {
+		latency += time.Duration(p.rand.Int63n(int64(faults.JitterMs) * int64(time.Millisecond)))
+	}
+	drop := p.rand.Float64() < faults.DropRate
+	fail := p.rand.Float64() < faults.ErrorRate
+	p.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if drop {
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	if fail {
+		http.Error(w, "Injected fault", http.StatusServiceUnavailable)
+		return
+	}
+
+	if faults.Bandwidth > 0 {
+		r.Body = &throttledReader{ReadCloser: r.Body, link: &p.upload, bandwidth: faults.Bandwidth}
+		w = &throttledWriter{ResponseWriter: w, link: &p.download, bandwidth: faults.Bandwidth}
+	}
+
+	p.reverseProxy.ServeHTTP(w, r)
+}
+
+// link is one direction of a degraded link. Transfers share its bandwidth
+// by reserving their transfer time one after the other.
+type link struct {
+	mu sync.Mutex
+	// End of the transfers reserved so far
+	busyUntil time.Time
+}
+
+// throttle sleeps until n bytes are transferred at bandwidth bytes per second
+// after the transfers already reserved.
+func (l *link) throttle(n int, bandwidth int) {
+	if n <= 0 {
+		return
+	}
+	d := time.Duration(n) * time.Second / time.Duration(bandwidth)
+
+	l.mu.Lock()
+	start := time.Now()
+	if l.busyUntil.After(start) {
+		start = l.busyUntil
+	}
+	l.busyUntil = start.Add(d)
+	done := l.busyUntil
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(done))
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	link      *link
+	bandwidth int
+}
+
+func (r *throttledReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.link.throttle(n, r.bandwidth)
+	return n, err
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	link      *link
+	bandwidth int
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	w.link.throttle(len(b), w.bandwidth)
+	return w.ResponseWriter.Write(b)
+}
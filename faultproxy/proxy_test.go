@@ -0,0 +1,78 @@
+package faultproxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/mockfactomd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyFaults(t *testing.T) {
+	require := require.New(t)
+
+	srv := httptest.NewServer(mockfactomd.NewServer(mockfactomd.DefaultConfig()).Handler())
+	defer srv.Close()
+
+	proxy, err := New(srv.URL + "/v2")
+	require.NoError(err)
+	endpoint, err := proxy.Start()
+	require.NoError(err)
+	defer proxy.Close()
+
+	cli := factomd.NewClient(endpoint)
+	ctx := context.Background()
+
+	_, _, err = cli.CurrentBlockAndMinute(ctx)
+	require.NoError(err)
+
+	proxy.SetFaults(Faults{ErrorRate: 1})
+	_, _, err = cli.CurrentBlockAndMinute(ctx)
+	require.Error(err)
+
+	proxy.SetFaults(Faults{DropRate: 1})
+	_, _, err = cli.CurrentBlockAndMinute(ctx)
+	require.Error(err)
+
+	proxy.SetFaults(Faults{LatencyMs: 100})
+	start := time.Now()
+	_, _, err = cli.CurrentBlockAndMinute(ctx)
+	require.NoError(err)
+	require.GreaterOrEqual(int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func TestProxyBandwidthIsShared(t *testing.T) {
+	require := require.New(t)
+
+	srv := httptest.NewServer(mockfactomd.NewServer(mockfactomd.DefaultConfig()).Handler())
+	defer srv.Close()
+
+	proxy, err := New(srv.URL + "/v2")
+	require.NoError(err)
+	endpoint, err := proxy.Start()
+	require.NoError(err)
+	defer proxy.Close()
+
+	cli := factomd.NewClient(endpoint)
+	ctx := context.Background()
+	// A commit is about 300 bytes of request
+	commit := make([]byte, 136)
+
+	proxy.SetFaults(Faults{Bandwidth: 3000})
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cli.CommitEntry(ctx, commit)
+		}()
+	}
+	wg.Wait()
+	// 10 requests share the link instead of each getting the full bandwidth
+	require.GreaterOrEqual(int64(time.Since(start)), int64(time.Second))
+}
@@ -0,0 +1,447 @@
+// Package hostmetrics samples the resource usage of the host,
+// and optionally of the factomd process, from /proc.
+package hostmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	_log "github.com/PaulBernier/chockagent/log"
+)
+
+const (
+	// Clock ticks per second of the CPU times in /proc, USER_HZ being 100 on Linux
+	clockTicks = 100
+)
+
+var (
+	log = _log.GetLog()
+	// PID or name of the factomd process to sample, if any
+	factomdPID  int
+	factomdName string
+)
+
+func init() {
+	if os.Getenv("FACTOMD_PID") != "" {
+		pid, err := strconv.Atoi(os.Getenv("FACTOMD_PID"))
+		if err != nil || pid <= 0 {
+			log.WithError(err).Fatalf("Failed to parse FACTOMD_PID: [%s]", os.Getenv("FACTOMD_PID"))
+		}
+		factomdPID = pid
+	}
+	factomdName = os.Getenv("FACTOMD_PROCESS_NAME")
+}
+
+// Sample of the resource usage. Rates are averaged since the previous sample,
+// and are 0 for the first one.
+type Sample struct {
+	// Seconds since epoch
+	Timestamp int64 `json:"timestamp"`
+	// Percentage of the time all the CPUs were busy
+	CPU   float64 `json:"cpu"`
+	Load1 float64 `json:"load1"`
+	// Bytes
+	MemoryTotal uint64 `json:"memoryTotal"`
+	MemoryUsed  uint64 `json:"memoryUsed"`
+	// Bytes per second
+	DiskRead  float64 `json:"diskRead"`
+	DiskWrite float64 `json:"diskWrite"`
+	NetRx     float64 `json:"netRx"`
+	NetTx     float64 `json:"netTx"`
+	// Usage of the factomd process, if configured and found
+	Process *ProcessSample `json:"process,omitempty"`
+}
+
+type ProcessSample struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+	// Percentage of one CPU
+	CPU     float64 `json:"cpu"`
+	RSS     uint64  `json:"rss"`
+	Threads int     `json:"threads"`
+	// Bytes per second, 0 if /proc/<pid>/io cannot be read
+	DiskRead  float64 `json:"diskRead"`
+	DiskWrite float64 `json:"diskWrite"`
+}
+
+// counters are the cumulative values of /proc rates are computed from.
+type counters struct {
+	time          time.Time
+	cpuTotal      uint64
+	cpuIdle       uint64
+	diskRead      uint64
+	diskWrite     uint64
+	netRx         uint64
+	netTx         uint64
+	pid           int
+	procCPU       uint64
+	procDiskRead  uint64
+	procDiskWrite uint64
+}
+
+// Sampler samples the host resource usage. It is not safe for concurrent use.
+type Sampler struct {
+	root string
+	pid  int
+	name string
+	prev *counters
+}
+
+// NewSampler returns a sampler of the host and of the factomd process
+// set by FACTOMD_PID or FACTOMD_PROCESS_NAME.
+func NewSampler() *Sampler {
+	return newSampler("/proc", factomdPID, factomdName)
+}
+
+func newSampler(root string, pid int, name string) *Sampler {
+	return &Sampler{root: root, pid: pid, name: name}
+}
+
+// Sample reads the current resource usage.
+func (s *Sampler) Sample(now time.Time) (Sample, error) {
+	sample := Sample{Timestamp: now.Unix()}
+	cur := counters{time: now}
+
+	var err error
+	if cur.cpuTotal, cur.cpuIdle, err = s.readCPU(); err != nil {
+		return sample, err
+	}
+	if sample.MemoryTotal, sample.MemoryUsed, err = s.readMemory(); err != nil {
+		return sample, err
+	}
+	if sample.Load1, err = s.readLoad(); err != nil {
+		return sample, err
+	}
+	if cur.diskRead, cur.diskWrite, err = s.readDisks(); err != nil {
+		return sample, err
+	}
+	if cur.netRx, cur.netTx, err = s.readNetwork(); err != nil {
+		return sample, err
+	}
+
+	process := s.sampleProcess(&cur)
+
+	if prev := s.prev; prev != nil {
+		elapsed := cur.time.Sub(prev.time).Seconds()
+		if total := cur.cpuTotal - prev.cpuTotal; total > 0 {
+			sample.CPU = 100 * (1 - float64(cur.cpuIdle-prev.cpuIdle)/float64(total))
+		}
+		if elapsed > 0 {
+			sample.DiskRead = rate(prev.diskRead, cur.diskRead, elapsed)
+			sample.DiskWrite = rate(prev.diskWrite, cur.diskWrite, elapsed)
+			sample.NetRx = rate(prev.netRx, cur.netRx, elapsed)
+			sample.NetTx = rate(prev.netTx, cur.netTx, elapsed)
+			if process != nil && prev.pid == cur.pid {
+				process.CPU = 100 * rate(prev.procCPU, cur.procCPU, elapsed) / clockTicks
+				process.DiskRead = rate(prev.procDiskRead, cur.procDiskRead, elapsed)
+				process.DiskWrite = rate(prev.procDiskWrite, cur.procDiskWrite, elapsed)
+			}
+		}
+	}
+	sample.Process = process
+	s.prev = &cur
+
+	return sample, nil
+}
+
+// rate returns the rate of a counter, 0 if it was reset.
+func rate(prev, cur uint64, elapsed float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / elapsed
+}
+
+// sampleProcess samples the factomd process, looking it up by name
+// if no PID is configured. It returns nil if the process is not found.
+func (s *Sampler) sampleProcess(cur *counters) *ProcessSample {
+	pid := s.pid
+	if pid == 0 {
+		if s.name == "" {
+			return nil
+		}
+		// The process may have been restarted
+		if s.prev != nil && s.prev.pid != 0 && s.processName(s.prev.pid) == s.name {
+			pid = s.prev.pid
+		} else if pid = s.findProcess(s.name); pid == 0 {
+			return nil
+		}
+	}
+
+	process, cpu, err := s.readProcess(pid)
+	if err != nil {
+		return nil
+	}
+	cur.pid = pid
+	cur.procCPU = cpu
+	// Reading the I/O of another user's process requires privileges
+	cur.procDiskRead, cur.procDiskWrite, _ = s.readProcessIO(pid)
+	return &process
+}
+
+func (s *Sampler) path(elem ...string) string {
+	return filepath.Join(append([]string{s.root}, elem...)...)
+}
+
+// readCPU returns the total and idle CPU times of the host.
+func (s *Sampler) readCPU() (uint64, uint64, error) {
+	data, err := ioutil.ReadFile(s.path("stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	line := strings.SplitN(string(data), "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) < 6 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("Unexpected /proc/stat format")
+	}
+
+	var total, idle uint64
+	// user nice system idle iowait irq softirq steal, guest time being
+	// already accounted in user time
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += value
+		if i == 3 || i == 4 {
+			idle += value
+		}
+	}
+	return total, idle, nil
+}
+
+// readMemory returns the total and used memory of the host in bytes.
+func (s *Sampler) readMemory() (uint64, uint64, error) {
+	f, err := os.Open(s.path("meminfo"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var total, available uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = value * 1024
+		case "MemAvailable:":
+			available = value * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if total < available {
+		return 0, 0, fmt.Errorf("Unexpected /proc/meminfo format")
+	}
+	return total, total - available, nil
+}
+
+func (s *Sampler) readLoad() (float64, error) {
+	data, err := ioutil.ReadFile(s.path("loadavg"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("Unexpected /proc/loadavg format")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// readDisks returns the bytes read and written by the disks of the host.
+// Partitions, listed after their disk, and virtual devices are not counted.
+func (s *Sampler) readDisks() (uint64, uint64, error) {
+	f, err := os.Open(s.path("diskstats"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var read, written uint64
+	var disks []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		name := fields[2]
+		if isVirtualDevice(name) || isPartition(name, disks) {
+			continue
+		}
+		disks = append(disks, name)
+
+		sectorsRead, err1 := strconv.ParseUint(fields[5], 10, 64)
+		sectorsWritten, err2 := strconv.ParseUint(fields[9], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// Sectors of 512 bytes whatever the device
+		read += sectorsRead * 512
+		written += sectorsWritten * 512
+	}
+	return read, written, scanner.Err()
+}
+
+func isVirtualDevice(name string) bool {
+	for _, prefix := range []string{"loop", "ram", "zram", "dm-", "sr"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPartition(name string, disks []string) bool {
+	for _, disk := range disks {
+		if strings.HasPrefix(name, disk) {
+			return true
+		}
+	}
+	return false
+}
+
+// readNetwork returns the bytes received and sent by the interfaces of the host,
+// loopback excluded.
+func (s *Sampler) readNetwork() (uint64, uint64, error) {
+	f, err := os.Open(s.path("net", "dev"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var rx, tx uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			// Header
+			continue
+		}
+		if strings.TrimSpace(line[:i]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(line[i+1:])
+		if len(fields) < 9 {
+			continue
+		}
+		received, err1 := strconv.ParseUint(fields[0], 10, 64)
+		sent, err2 := strconv.ParseUint(fields[8], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		rx += received
+		tx += sent
+	}
+	return rx, tx, scanner.Err()
+}
+
+// readProcess returns the usage of a process and its cumulative CPU time in clock ticks.
+func (s *Sampler) readProcess(pid int) (ProcessSample, uint64, error) {
+	process := ProcessSample{PID: pid}
+	data, err := ioutil.ReadFile(s.path(strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return process, 0, err
+	}
+
+	// The name may contain spaces and parentheses
+	stat := string(data)
+	open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return process, 0, fmt.Errorf("Unexpected /proc/%d/stat format", pid)
+	}
+	process.Name = stat[open+1 : end]
+	// Fields from the state (3rd field)
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return process, 0, fmt.Errorf("Unexpected /proc/%d/stat format", pid)
+	}
+	utime, err1 := strconv.ParseUint(fields[11], 10, 64)
+	stime, err2 := strconv.ParseUint(fields[12], 10, 64)
+	threads, err3 := strconv.Atoi(fields[17])
+	rss, err4 := strconv.ParseUint(fields[21], 10, 64)
+	for _, err := range []error{err1, err2, err3, err4} {
+		if err != nil {
+			return process, 0, err
+		}
+	}
+	process.Threads = threads
+	process.RSS = rss * uint64(os.Getpagesize())
+
+	return process, utime + stime, nil
+}
+
+// readProcessIO returns the bytes read and written to storage by a process.
+func (s *Sampler) readProcessIO(pid int) (uint64, uint64, error) {
+	f, err := os.Open(s.path(strconv.Itoa(pid), "io"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var read, written uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "read_bytes:":
+			read = value
+		case "write_bytes:":
+			written = value
+		}
+	}
+	return read, written, scanner.Err()
+}
+
+func (s *Sampler) processName(pid int) string {
+	data, err := ioutil.ReadFile(s.path(strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// findProcess returns the PID of the first process with the given name, 0 if none.
+func (s *Sampler) findProcess(name string) int {
+	dirs, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return 0
+	}
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil || !dir.IsDir() {
+			continue
+		}
+		if s.processName(pid) == name {
+			return pid
+		}
+	}
+	return 0
+}
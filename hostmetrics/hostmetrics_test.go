@@ -0,0 +1,87 @@
+package hostmetrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeProc writes a fake /proc with the given cumulative counters.
+func writeProc(t *testing.T, root string, cpuBusy, cpuIdle, sectors, netBytes, procTicks int) {
+	files := map[string]string{
+		"stat":    fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\ncpu0 0 0 0 0 0 0 0 0 0 0\n", cpuBusy, cpuIdle),
+		"meminfo": "MemTotal:       2048 kB\nMemFree:         512 kB\nMemAvailable:   1024 kB\n",
+		"loadavg": "0.50 0.40 0.30 1/100 1234\n",
+		"diskstats": fmt.Sprintf("   7       0 loop0 100 0 %[1]d 0 0 0 0 0 0 0 0\n"+
+			"   8       0 sda 10 0 %[1]d 0 10 0 %[1]d 0 0 0 0\n"+
+			"   8       1 sda1 10 0 %[1]d 0 10 0 %[1]d 0 0 0 0\n", sectors),
+		"net/dev": "Inter-|   Receive                            |  Transmit\n" +
+			" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets\n" +
+			fmt.Sprintf("    lo: %[1]d 0 0 0 0 0 0 0 %[1]d 0 0 0 0 0 0 0\n", netBytes) +
+			fmt.Sprintf("  eth0: %[1]d 0 0 0 0 0 0 0 %[1]d 0 0 0 0 0 0 0\n", netBytes),
+		"42/comm": "factomd\n",
+		"42/stat": fmt.Sprintf("42 (factomd) S 1 42 42 0 -1 0 0 0 0 0 %d 0 0 0 20 0 12 0 100 1000 10 0 0\n", procTicks),
+		"42/io":   fmt.Sprintf("rchar: 0\nwchar: 0\nread_bytes: %[1]d\nwrite_bytes: %[1]d\n", sectors),
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestSample(t *testing.T) {
+	require := require.New(t)
+	root, err := ioutil.TempDir("", "proc")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	sampler := newSampler(root, 0, "factomd")
+	now := time.Now()
+	writeProc(t, root, 100, 100, 0, 0, 0)
+	sample, err := sampler.Sample(now)
+	require.NoError(err)
+	require.Zero(sample.CPU)
+	require.EqualValues(2048*1024, sample.MemoryTotal)
+	require.EqualValues(1024*1024, sample.MemoryUsed)
+	require.Equal(0.5, sample.Load1)
+	require.NotNil(sample.Process)
+	require.Equal(42, sample.Process.PID)
+	require.Equal(12, sample.Process.Threads)
+
+	// 2 seconds later, 75% busy
+	writeProc(t, root, 250, 150, 20, 1000, 100)
+	sample, err = sampler.Sample(now.Add(2 * time.Second))
+	require.NoError(err)
+	require.Equal(75.0, sample.CPU)
+	// Only sda is counted
+	require.Equal(20*512/2.0, sample.DiskRead)
+	require.Equal(20*512/2.0, sample.DiskWrite)
+	// Loopback excluded
+	require.Equal(500.0, sample.NetRx)
+	require.Equal(500.0, sample.NetTx)
+	require.Equal(50.0, sample.Process.CPU)
+	require.Equal(10.0, sample.Process.DiskWrite)
+}
+
+func TestSampleHost(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("No /proc")
+	}
+	require := require.New(t)
+
+	sampler := newSampler("/proc", os.Getpid(), "")
+	_, err := sampler.Sample(time.Now())
+	require.NoError(err)
+	sample, err := sampler.Sample(time.Now())
+	require.NoError(err)
+	require.NotZero(sample.MemoryTotal)
+	require.NotNil(sample.Process)
+	require.NotZero(sample.Process.RSS)
+}
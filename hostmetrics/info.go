@@ -0,0 +1,34 @@
+package hostmetrics
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// HostInfo describes the host the agent runs on.
+type HostInfo struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	// Empty if unknown
+	Kernel string `json:"kernel,omitempty"`
+	CPUs   int    `json:"cpus"`
+	// Bytes, 0 if unknown
+	MemoryTotal uint64 `json:"memoryTotal"`
+}
+
+// Info returns the description of the host.
+func Info() HostInfo {
+	info := HostInfo{OS: runtime.GOOS, Arch: runtime.GOARCH, CPUs: runtime.NumCPU()}
+	info.Hostname, _ = os.Hostname()
+
+	sampler := NewSampler()
+	if data, err := ioutil.ReadFile(sampler.path("sys", "kernel", "osrelease")); err == nil {
+		info.Kernel = strings.TrimSpace(string(data))
+	}
+	info.MemoryTotal, _, _ = sampler.readMemory()
+
+	return info
+}
@@ -0,0 +1,276 @@
+// Package journal records the entries submitted by a load
+// into local JSONL files, rotated by size.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	_log "github.com/PaulBernier/chockagent/log"
+)
+
+var (
+	log = _log.GetLog()
+	// Journals are disabled unless a directory is set
+	dir     string
+	maxSize int64 = 100 << 20
+)
+
+func init() {
+	dir = os.Getenv("JOURNAL_DIR")
+	if os.Getenv("JOURNAL_MAX_SIZE_MB") != "" {
+		size, err := strconv.ParseInt(os.Getenv("JOURNAL_MAX_SIZE_MB"), 10, 64)
+		if err != nil || size <= 0 {
+			log.WithError(err).Fatalf("Failed to parse JOURNAL_MAX_SIZE_MB: [%s]",
+				os.Getenv("JOURNAL_MAX_SIZE_MB"))
+		}
+		maxSize = size << 20
+	}
+}
+
+// Dir returns the directory of the journals, as configured by JOURNAL_DIR.
+// Journals are disabled if empty.
+func Dir() string {
+	return dir
+}
+
+// Kind of record.
+type Kind string
+
+const (
+	// Outcome of the submission of an entry
+	Submission Kind = "submission"
+	// Outcome of the reveal of an entry submitted earlier
+	Reveal Kind = "reveal"
+	// Outcome of the submission of a deliberately invalid entry
+	InvalidSubmission Kind = "invalid-submission"
+	// Outcome of the submission again of an entry submitted earlier
+	Replay Kind = "replay"
+	// Whether a submitted entry was later seen by a probe node
+	Confirmation Kind = "confirmation"
+	// Start and end of the job, with the current block height
+	Start Kind = "start"
+	End   Kind = "end"
+)
+
+const (
+	ResultAccepted = "accepted"
+	// Commit accepted, the entry is revealed later or never
+	ResultCommitted = "committed"
+	ResultError     = "error"
+
+	StatusConfirmed   = "confirmed"
+	StatusUnconfirmed = "unconfirmed"
+)
+
+// Record of the journal, a single JSON line.
+type Record struct {
+	Kind      Kind   `json:"kind"`
+	JobID     string `json:"jobId"`
+	EntryHash string `json:"entryHash"`
+	ChainID   string `json:"chainId,omitempty"`
+	// Size of the entry in bytes
+	Size   int   `json:"size,omitempty"`
+	ECCost uint8 `json:"ecCost,omitempty"`
+	// Commit timestamp in milliseconds
+	Timestamp int64 `json:"timestamp,omitempty"`
+	// Latency of the RPC calls in milliseconds
+	Latency float64 `json:"latency,omitempty"`
+	Result  string  `json:"result,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	// Kind of invalid traffic of an invalid submission
+	Invalid string `json:"invalid,omitempty"`
+	// Confirmation status
+	Status string `json:"status,omitempty"`
+	// Block height of the start and end records
+	Height int `json:"height,omitempty"`
+}
+
+// ValidateJobID returns an error if a job ID cannot name the files of a job,
+// such as one with path separators or glob metacharacters.
+func ValidateJobID(jobID string) error {
+	if jobID == "" || strings.Contains(jobID, "..") || strings.ContainsAny(jobID, `/\*?[]`) {
+		return fmt.Errorf("Invalid job ID [%s]", jobID)
+	}
+	return nil
+}
+
+// Writer appends records to the journal of a job, safe for concurrent use.
+// A nil Writer discards the records.
+type Writer struct {
+	dir     string
+	jobID   string
+	maxSize int64
+
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	size   int64
+	part   int
+	closed bool
+}
+
+// Open opens a journal for a job in the directory set by JOURNAL_DIR,
+// or returns a nil Writer if journals are disabled.
+func Open(jobID string) (*Writer, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	return OpenIn(dir, jobID, maxSize)
+}
+
+// OpenIn opens a journal for a job in the given directory. Files are named
+// <jobID>.<part>.jsonl and a new part is started once maxSize bytes are written.
+// The journal of a job cannot be opened again, to not mix the records of two jobs.
+func OpenIn(dir string, jobID string, maxSize int64) (*Writer, error) {
+	files, err := Files(dir, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		return nil, fmt.Errorf("Journal of job [%s] already exists", jobID)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &Writer{dir: dir, jobID: jobID, maxSize: maxSize}
+	if err := w.rotate(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// rotate closes the current file, if any, and starts the next part.
+func (w *Writer) rotate() error {
+	if w.file != nil {
+		if err := w.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	w.part++
+	path := filepath.Join(w.dir, fmt.Sprintf("%s.%d.jsonl", w.jobID, w.part))
+	// Fails if another job of the same ID started in the meantime
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.buf = bufio.NewWriterSize(file, 64<<10)
+	w.size = 0
+	return nil
+}
+
+func (w *Writer) closeFile() error {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Write appends a record. Failures are logged, they must not stop the load.
+func (w *Writer) Write(r Record) {
+	if w == nil {
+		return
+	}
+	r.JobID = w.jobID
+	line, err := json.Marshal(r)
+	if err != nil {
+		log.WithError(err).Warn("Failed to marshal journal record")
+		return
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if w.size > 0 && w.size+int64(len(line)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			log.WithError(err).Error("Failed to rotate journal")
+			w.closed = true
+			return
+		}
+	}
+	n, err := w.buf.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		log.WithError(err).Warn("Failed to write journal record")
+	}
+}
+
+// Close flushes and closes the journal. Later records are discarded.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.closeFile()
+}
+
+// Files returns the files of the journal of a job, in order.
+func Files(dir string, jobID string) ([]string, error) {
+	if err := ValidateJobID(jobID); err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, jobID+".*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The glob also matches the journals of jobs whose ID starts with <jobID>.
+	parts := make(map[string]int)
+	var files []string
+	for _, file := range matches {
+		part := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), jobID+"."), ".jsonl")
+		n, err := strconv.Atoi(part)
+		if err != nil || part != strconv.Itoa(n) || n < 1 {
+			continue
+		}
+		parts[file] = n
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return parts[files[i]] < parts[files[j]] })
+	return files, nil
+}
+
+// Read reads all the records of the given journal files.
+func Read(files ...string) ([]Record, error) {
+	var records []Record
+	for _, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var r Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("Invalid record in %s: %s", path, err)
+			}
+			records = append(records, r)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
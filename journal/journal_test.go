@@ -0,0 +1,86 @@
+package journal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalRotation(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	w, err := OpenIn(dir, "job", 1000)
+	require.NoError(err)
+	for i := 0; i < 100; i++ {
+		w.Write(Record{Kind: Submission, EntryHash: fmt.Sprintf("%064d", i), Result: ResultAccepted})
+	}
+	require.NoError(w.Close())
+	// Discarded once closed
+	w.Write(Record{Kind: Submission})
+
+	files, err := Files(dir, "job")
+	require.NoError(err)
+	require.True(len(files) > 10)
+	records, err := Read(files...)
+	require.NoError(err)
+	require.Len(records, 100)
+	for i, r := range records {
+		require.Equal("job", r.JobID)
+		require.Equal(fmt.Sprintf("%064d", i), r.EntryHash)
+	}
+}
+
+func TestNilWriter(t *testing.T) {
+	var w *Writer
+	w.Write(Record{Kind: Submission})
+	require.NoError(t, w.Close())
+}
+
+func TestFilesOfJob(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.1.jsonl", "a.2.jsonl", "a.10.jsonl", "a.b.1.jsonl", "a.x.jsonl"} {
+		require.NoError(ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	files, err := Files(dir, "a")
+	require.NoError(err)
+	require.Equal([]string{
+		filepath.Join(dir, "a.1.jsonl"),
+		filepath.Join(dir, "a.2.jsonl"),
+		filepath.Join(dir, "a.10.jsonl")}, files)
+
+	for _, jobID := range []string{"", "..", "../a", "a/b", `a\b`, "a*", "a?", "[a]"} {
+		_, err := Files(dir, jobID)
+		require.Error(err, jobID)
+		_, err = OpenIn(dir, jobID, 1000)
+		require.Error(err, jobID)
+	}
+}
+
+func TestExistingJobRefused(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	w, err := OpenIn(dir, "job", 1000)
+	require.NoError(err)
+	require.NoError(w.Close())
+
+	_, err = OpenIn(dir, "job", 1000)
+	require.EqualError(err, "Journal of job [job] already exists")
+	w, err = OpenIn(dir, "job.2", 1000)
+	require.NoError(err)
+	require.NoError(w.Close())
+}
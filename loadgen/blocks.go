@@ -0,0 +1,278 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulBernier/chockagent/factomd"
+)
+
+const (
+	// Maximum number of own entries tracked until seen in a block
+	maxTrackedEntries = 1000000
+	// Directory blocks fetched per poll when catching up
+	maxBlocksPerPoll = 10
+)
+
+var (
+	// Variable so that tests can observe fast blocks
+	blockPollingInterval = 10 * time.Second
+)
+
+// BlockStats is the throughput observed in a directory block.
+type BlockStats struct {
+	Height int `json:"height"`
+	// Start of the block in seconds since epoch
+	Timestamp int64 `json:"timestamp"`
+	// Seconds since the previous block, 0 if unknown
+	Interval float64 `json:"interval"`
+	// Entries in the target chains, and those submitted by the load
+	Entries    int `json:"entries"`
+	OwnEntries int `json:"ownEntries"`
+	// Own entries by minute of the block, according to the entry timestamps
+	OwnEntriesPerMinute [10]int `json:"ownEntriesPerMinute"`
+	// Rate of own entries in the block, and rate of submission
+	// of the load since the previous poll
+	BlockEPS      float64 `json:"blockEps"`
+	SubmissionEPS float64 `json:"submissionEps"`
+}
+
+// BlockThroughput aggregates the blocks observed during a load.
+type BlockThroughput struct {
+	Blocks       int     `json:"blocks"`
+	Entries      uint64  `json:"entries"`
+	OwnEntries   uint64  `json:"ownEntries"`
+	MeanInterval float64 `json:"meanInterval"`
+	BlockEPS     float64 `json:"blockEps"`
+}
+
+type blockRecorder struct {
+	mu         sync.Mutex
+	throughput BlockThroughput
+	// Blocks with a known interval
+	intervals   int
+	intervalSum float64
+	// Own entries of the blocks with a known interval
+	ownInIntervals uint64
+}
+
+func (br *blockRecorder) record(bs BlockStats) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.throughput.Blocks++
+	br.throughput.Entries += uint64(bs.Entries)
+	br.throughput.OwnEntries += uint64(bs.OwnEntries)
+	if bs.Interval > 0 {
+		br.intervals++
+		br.intervalSum += bs.Interval
+		br.ownInIntervals += uint64(bs.OwnEntries)
+	}
+}
+
+func (br *blockRecorder) snapshot() *BlockThroughput {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	if br.throughput.Blocks == 0 {
+		return nil
+	}
+	throughput := br.throughput
+	if br.intervals > 0 {
+		throughput.MeanInterval = br.intervalSum / float64(br.intervals)
+		throughput.BlockEPS = float64(br.ownInIntervals) / br.intervalSum
+	}
+	return &throughput
+}
+
+// entrySet tracks the entries submitted by the load until seen in a block.
+type entrySet struct {
+	mu      sync.Mutex
+	entries map[[32]byte]struct{}
+}
+
+func newEntrySet() *entrySet {
+	return &entrySet{entries: make(map[[32]byte]struct{})}
+}
+
+func (es *entrySet) add(hash []byte) {
+	var h [32]byte
+	copy(h[:], hash)
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if len(es.entries) < maxTrackedEntries {
+		es.entries[h] = struct{}{}
+	}
+}
+
+// remove returns true if the entry was tracked.
+func (es *entrySet) remove(hash [32]byte) bool {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	_, ok := es.entries[hash]
+	delete(es.entries, hash)
+	return ok
+}
+
+// observeBlocks follows the new directory blocks during the load and
+// reports the throughput of the target chains.
+func (lg *LoadGenerator) observeBlocks(chainIDs []string) {
+	ticker := time.NewTicker(blockPollingInterval)
+	defer ticker.Stop()
+
+	chains := make(map[string]bool)
+	for _, chainID := range chainIDs {
+		chains[chainID] = true
+	}
+	node := lg.factomd.Primary()
+
+	// Only the blocks created after the start of the load are observed,
+	// from the one being built at the start if the head is unknown
+	var last *factomd.DirectoryBlockResult
+	walker := blockWalker{node: node, height: lg.startHeight - 1}
+	lastPoll := time.Now()
+	lastSubmitted := atomic.LoadUint64(&lg.stats.submitted)
+	if keyMR, err := node.DirectoryBlockHead(lg.ctx); err == nil {
+		if dblock, err := node.DirectoryBlock(lg.ctx, keyMR); err == nil {
+			last = &dblock
+			walker.height = dblock.Header.SequenceNumber
+		}
+	}
+
+	for {
+		select {
+		case <-lg.ctx.Done():
+			return
+		case now := <-ticker.C:
+			dblocks, err := walker.next(lg.ctx)
+			if err != nil {
+				log.WithError(err).Warn("Failed to fetch directory blocks")
+				continue
+			}
+			if len(dblocks) == 0 {
+				continue
+			}
+
+			submitted := atomic.LoadUint64(&lg.stats.submitted)
+			submissionEPS := float64(submitted-lastSubmitted) / now.Sub(lastPoll).Seconds()
+			lastPoll, lastSubmitted = now, submitted
+
+			for i := range dblocks {
+				bs, err := lg.blockStats(node, dblocks[i], last, chains)
+				if err != nil {
+					log.WithError(err).Warn("Failed to fetch entry blocks")
+				}
+				bs.SubmissionEPS = submissionEPS
+				lg.stats.blocks.record(bs)
+				lg.emit(Event{Type: "block-stats", Payload: bs})
+				last = &dblocks[i]
+			}
+		}
+	}
+}
+
+// blockWalker walks back the directory blocks created after a height.
+type blockWalker struct {
+	node *factomd.Client
+	// Height of the last block returned
+	height int
+	// Blocks fetched so far walking back from the head, newest first
+	fetched []factomd.DirectoryBlockResult
+}
+
+// next returns the directory blocks created since the last call, in order.
+// When catching up, at most maxBlocksPerPoll blocks are fetched per call and
+// nothing is returned until the walk is back to the last block returned.
+func (bw *blockWalker) next(ctx context.Context) ([]factomd.DirectoryBlockResult, error) {
+	var keyMR string
+	if n := len(bw.fetched); n > 0 {
+		keyMR = bw.fetched[n-1].Header.PrevBlockKeyMR
+	} else {
+		head, err := bw.node.DirectoryBlockHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keyMR = head
+	}
+
+	for i := 0; i < maxBlocksPerPoll; i++ {
+		dblock, err := bw.node.DirectoryBlock(ctx, keyMR)
+		if err != nil {
+			return nil, err
+		}
+		if dblock.Header.SequenceNumber <= bw.height {
+			return bw.caughtUp(), nil
+		}
+		bw.fetched = append(bw.fetched, dblock)
+		if dblock.Header.SequenceNumber == bw.height+1 || dblock.Header.SequenceNumber == 0 {
+			return bw.caughtUp(), nil
+		}
+		keyMR = dblock.Header.PrevBlockKeyMR
+	}
+	return nil, nil
+}
+
+// caughtUp returns the blocks fetched, oldest first, and resets the walk.
+func (bw *blockWalker) caughtUp() []factomd.DirectoryBlockResult {
+	dblocks := bw.fetched
+	bw.fetched = nil
+	for i, j := 0, len(dblocks)-1; i < j; i, j = i+1, j-1 {
+		dblocks[i], dblocks[j] = dblocks[j], dblocks[i]
+	}
+	if len(dblocks) > 0 {
+		bw.height = dblocks[len(dblocks)-1].Header.SequenceNumber
+	}
+	return dblocks
+}
+
+// blockStats counts the entries of the target chains in a directory block.
+func (lg *LoadGenerator) blockStats(node *factomd.Client, dblock factomd.DirectoryBlockResult,
+	prev *factomd.DirectoryBlockResult, chains map[string]bool) (BlockStats, error) {
+	bs := BlockStats{Height: dblock.Header.SequenceNumber, Timestamp: dblock.Header.Timestamp}
+	minuteDuration := int64(60)
+	if prev != nil && prev.Header.SequenceNumber == bs.Height-1 {
+		interval := dblock.Header.Timestamp - prev.Header.Timestamp
+		bs.Interval = float64(interval)
+		if interval >= 10 {
+			minuteDuration = interval / 10
+		}
+	}
+
+	for _, ebRef := range dblock.EntryBlockList {
+		if !chains[ebRef.ChainID] {
+			continue
+		}
+		eblock, err := node.EntryBlock(lg.ctx, ebRef.KeyMR)
+		if err != nil {
+			return bs, err
+		}
+
+		bs.Entries += len(eblock.EntryList)
+		for _, entry := range eblock.EntryList {
+			var hash [32]byte
+			if _, err := hex.Decode(hash[:], []byte(entry.EntryHash)); err != nil {
+				continue
+			}
+			if !lg.ownEntries.remove(hash) {
+				continue
+			}
+			bs.OwnEntries++
+			minute := (entry.Timestamp - bs.Timestamp) / minuteDuration
+			if minute < 0 {
+				minute = 0
+			} else if minute > 9 {
+				minute = 9
+			}
+			bs.OwnEntriesPerMinute[minute]++
+		}
+	}
+	if bs.Interval > 0 {
+		bs.BlockEPS = float64(bs.OwnEntries) / bs.Interval
+	}
+
+	return bs, nil
+}
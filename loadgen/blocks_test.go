@@ -0,0 +1,46 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/mockfactomd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockWalkerCatchesUp(t *testing.T) {
+	require := require.New(t)
+	config := mockfactomd.DefaultConfig()
+	config.MinuteDuration = time.Millisecond
+	_, cluster, stop := startMockFactomd(t, config)
+	defer stop()
+	node := cluster.Primary()
+	ctx := context.Background()
+
+	// Block being built
+	start, _, err := node.CurrentBlockAndMinute(ctx)
+	require.NoError(err)
+	walker := blockWalker{node: node, height: start - 1}
+	// More new blocks than fetched per poll
+	for height := start; height < start+3*maxBlocksPerPoll; {
+		time.Sleep(10 * config.MinuteDuration)
+		height, _, err = node.CurrentBlockAndMinute(ctx)
+		require.NoError(err)
+	}
+
+	polls := 0
+	var dblocks []factomd.DirectoryBlockResult
+	for len(dblocks) == 0 {
+		polls++
+		dblocks, err = walker.next(ctx)
+		require.NoError(err)
+	}
+	require.GreaterOrEqual(polls, 3)
+	require.GreaterOrEqual(len(dblocks), 3*maxBlocksPerPoll)
+	for i, dblock := range dblocks {
+		require.Equal(start+i, dblock.Header.SequenceNumber)
+	}
+	require.Equal(dblocks[len(dblocks)-1].Header.SequenceNumber, walker.height)
+}
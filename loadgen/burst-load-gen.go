@@ -1,17 +1,16 @@
 package loadgen
 
 import (
-	"context"
 	"fmt"
 	"sync/atomic"
 	"time"
-
-	"github.com/PaulBernier/chockagent/factomd"
-	"golang.org/x/sync/semaphore"
 )
 
 type BurstLoadConfig struct {
 	NbEntries int `mapstructure:"nbEntries"`
+	// Compose and sign the entries before the burst starts,
+	// so that it only measures the ingestion by factomd
+	Precompose bool `mapstructure:"precompose"`
 }
 
 func (blc BurstLoadConfig) isValid() error {
@@ -23,62 +22,48 @@ func (blc BurstLoadConfig) isValid() error {
 }
 
 func (lg *LoadGenerator) runBurstLoad(config BurstLoadConfig, composer *RandomEntryComposer) {
+	if config.Precompose {
+		start := time.Now()
+		if !lg.precompose(composer, config.NbEntries) {
+			log.Info("Burst stopped while composing entries")
+			return
+		}
+		// Not accounted for in the duration of the load
+		duration := time.Now().Sub(start)
+		atomic.StoreInt64(&lg.stats.precomposeDuration, int64(duration))
+		log.WithField("duration", duration).
+			WithField("nb-entries", len(lg.precomposed)).
+			Info("Entries precomposed")
+	}
+
 	log.WithField("config", fmt.Sprintf("%+v", config)).
+		WithField("workers", lg.pool.workers).
 		Info("Burst load started")
 
-	// Limiting API calls concurrency
-	concurrency := int64(200)
-	sem := semaphore.NewWeighted(concurrency)
-	timeout := 10 * time.Second
 	start := time.Now()
-	var errorCount uint64
-
-	for i := 0; i < config.NbEntries; i++ {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		if err := sem.Acquire(ctx, 1); err != nil {
-			cancel()
-			log.WithError(err).Error("Burst aborted")
-			return
+	submitted := 0
+
+	// Enqueuing blocks while the workers are busy,
+	// so the burst goes as fast as factomd accepts entries
+	for ; submitted < config.NbEntries; submitted++ {
+		if !lg.waitResumed() || !lg.pool.enqueue(lg.ctx.Done()) {
+			log.WithField("submitted", submitted).Info("Burst stopped")
+			break
 		}
-		cancel()
-
-		go func() {
-			defer sem.Release(1)
-
-			commit, reveal, err := composer.Compose()
-
-			// This should never happen, it's a hard failure
-			if err != nil {
-				atomic.AddUint64(&errorCount, 1)
-				log.WithError(err).Error("Fatal: failed to compose entry")
-				return
-			}
-
-			err = factomd.CommitAndRevealEntry(commit, reveal)
-			// It is expected that API calls will start failing under heavy load
-			if err != nil {
-				atomic.AddUint64(&errorCount, 1)
-				log.WithError(err).Warn("Failed to submit entry")
-			}
-
-		}()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	// Wait for the semaphore to be fully released
+	// Wait for the queued entries to be submitted
 	// (to account for the full duration as well as all errors)
-	if err := sem.Acquire(ctx, concurrency); err != nil {
-		cancel()
-		log.WithError(err).Error("Failed to wait for the burst to finish")
-		return
-	}
-	cancel()
+	lg.pool.stop()
 
 	duration := time.Now().Sub(start)
+	stats := lg.stats.snapshot()
 	log.WithField("duration", duration).
-		WithField("eps", fmt.Sprintf("%.2f", float64(config.NbEntries)/duration.Seconds())).
-		WithField("errors", errorCount).
-		WithField("error-rate", fmt.Sprintf("%.2f%%", (100*float64(errorCount)/float64(config.NbEntries)))).
+		WithField("eps", fmt.Sprintf("%.2f", float64(submitted)/duration.Seconds())).
+		WithField("errors", stats.Errors).
+		WithField("error-rate", fmt.Sprintf("%.2f%%", errorRate(stats.Errors, uint64(submitted)))).
+		WithField("submit-latency-p50", stats.SubmitLatency.P50).
+		WithField("propagation-latency-p50", stats.PropagationLatency.P50).
 		Info("Burst load finished")
 
 }
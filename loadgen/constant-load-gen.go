@@ -2,10 +2,14 @@ package loadgen
 
 import (
 	"fmt"
+	"math"
 	"sync/atomic"
 	"time"
+)
 
-	"github.com/PaulBernier/chockagent/factomd"
+const (
+	// Shortest interval between two wake-ups of the constant load scheduler
+	minSchedulerTick = time.Millisecond
 )
 
 type ConstantLoadConfig struct {
@@ -20,60 +24,110 @@ func (clc ConstantLoadConfig) isValid() error {
 	return nil
 }
 
-func (lg *LoadGenerator) runConstantLoad(config ConstantLoadConfig, composer *RandomEntryComposer) {
-	interval := time.Duration(int64(1e6/config.EPS)) * time.Microsecond
+func (lg *LoadGenerator) runConstantLoad(config ConstantLoadConfig) {
+	tick := time.Duration(int64(1e6/config.EPS)) * time.Microsecond
+	if tick < minSchedulerTick {
+		tick = minSchedulerTick
+	}
 	log.WithField("config", fmt.Sprintf("%+v", config)).
-		WithField("interval", interval).
+		WithField("tick", tick).
+		WithField("workers", lg.pool.workers).
 		Info("Constant load started")
 
 	start := time.Now()
-	maxConcurrency := int64(100)
-	var errorCount uint64
-	var submitted uint64
-	var concurrentGoRoutines int64
-	ticker := time.NewTicker(interval)
+	scheduler := rateScheduler{start: start, eps: config.EPS}
+	atomic.StoreUint64(&lg.stats.targetEPS, math.Float64bits(config.EPS))
+	// Whether the queue is full, to only log the transitions
+	saturated := false
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-lg.stop:
+		case <-lg.ctx.Done():
+			lg.pool.stop()
+			stats := lg.stats.snapshot()
 			log.WithField("duration", time.Now().Sub(start)).
-				WithField("errors", errorCount).
-				WithField("error-rate", fmt.Sprintf("%.2f%%", (100*float64(errorCount)/float64(submitted)))).
+				WithField("errors", stats.Errors).
+				WithField("error-rate", fmt.Sprintf("%.2f%%", errorRate(stats.Errors, stats.Submitted))).
+				WithField("dropped", atomic.LoadUint64(&lg.pool.dropped)).
+				WithField("achieved-eps", fmt.Sprintf("%.2f", stats.AchievedEPS)).
 				Info("Constant load stopped")
 			return
-		case <-ticker.C:
-			// Abort if the entries cannot be inserted fast enough and
-			// go routines are starting piling up
-			if atomic.LoadInt64(&concurrentGoRoutines) > maxConcurrency {
+		case now := <-ticker.C:
+			if lg.gate.paused() {
+				// The entries due while paused are not caught up on resume
+				scheduler.skip(now)
+				continue
+			}
+			// If the entries cannot be inserted fast enough the queue stays full,
+			// the backlog overflows and the rate drops to what factomd can sustain
+			dropped := scheduler.dispatch(now, lg.pool.tryEnqueue, cap(lg.pool.queue))
+			lg.pool.drop(uint64(dropped))
+			queued := dropped == 0
+
+			if !queued && !saturated {
+				log.WithField("duration", time.Now().Sub(start)).
+					WithField("workers", lg.pool.workers).
+					Warn("Submit queue full, dropping entries")
+			} else if queued && saturated {
 				log.WithField("duration", time.Now().Sub(start)).
-					WithField("errors", errorCount).
-					WithField("max-concurrency", maxConcurrency).
-					Error("Aborting constant load due to too high concurrency")
-				lg.stop = nil
-				return
+					Info("Submit queue no longer full")
 			}
+			saturated = !queued
+		}
+	}
+}
+
+// rateScheduler accounts for the entries due since its start at a constant rate.
+// Entries are dispatched in batches when the rate exceeds the timer resolution,
+// without the rounding errors of a per-entry interval.
+type rateScheduler struct {
+	start      time.Time
+	eps        float64
+	dispatched uint64
+	// Entries due but not enqueued yet, carried over to the next wake-ups
+	backlog int
+}
 
-			go func() {
-				defer atomic.AddInt64(&concurrentGoRoutines, -1)
-				atomic.AddInt64(&concurrentGoRoutines, 1)
-				atomic.AddUint64(&submitted, 1)
+// due returns the number of entries to dispatch at time now.
+func (rs *rateScheduler) due(now time.Time) int {
+	total := uint64(now.Sub(rs.start).Seconds() * rs.eps)
+	if total <= rs.dispatched {
+		return 0
+	}
+	n := total - rs.dispatched
+	rs.dispatched = total
+	return int(n)
+}
 
-				commit, reveal, err := composer.Compose()
+// dispatch enqueues the entries due at time now along with the backlog.
+// Entries that do not fit are carried over, up to maxBacklog, the rest
+// being dropped. It returns the number of entries dropped.
+func (rs *rateScheduler) dispatch(now time.Time, enqueue func() bool, maxBacklog int) int {
+	rs.backlog += rs.due(now)
+	for rs.backlog > 0 && enqueue() {
+		rs.backlog--
+	}
 
-				// This should never happen, it's a hard failure
-				if err != nil {
-					atomic.AddUint64(&errorCount, 1)
-					log.WithError(err).Error("Fatal: failed to compose entry")
-					return
-				}
+	if rs.backlog <= maxBacklog {
+		return 0
+	}
+	dropped := rs.backlog - maxBacklog
+	rs.backlog = maxBacklog
+	return dropped
+}
 
-				err = factomd.CommitAndRevealEntry(commit, reveal)
-				// It is expected that API calls will start failing under heavy load
-				if err != nil {
-					atomic.AddUint64(&errorCount, 1)
-					log.WithError(err).Warn("Failed to submit entry")
-				}
-			}()
-		}
+// skip discards the entries due at time now and the backlog.
+func (rs *rateScheduler) skip(now time.Time) {
+	rs.due(now)
+	rs.backlog = 0
+}
+
+// errorRate returns the percentage of errors among the submitted entries.
+func errorRate(errors uint64, submitted uint64) float64 {
+	if submitted == 0 {
+		return 0
 	}
+	return 100 * float64(errors) / float64(submitted)
 }
@@ -0,0 +1,61 @@
+package loadgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateSchedulerKeepsLongRunRate(t *testing.T) {
+	require := require.New(t)
+	start := time.Now()
+	scheduler := rateScheduler{start: start, eps: 25000}
+
+	// Irregular wake-ups, coarser than the interval between entries
+	total := 0
+	now := start
+	for i := 0; i < 1000; i++ {
+		now = now.Add(time.Duration(500+i%7*300) * time.Microsecond)
+		total += scheduler.due(now)
+	}
+
+	require.Equal(int(now.Sub(start).Seconds()*25000), total)
+	require.Zero(scheduler.due(now))
+}
+
+func TestRateSchedulerCarriesBacklogOver(t *testing.T) {
+	require := require.New(t)
+	start := time.Now()
+	scheduler := rateScheduler{start: start, eps: 100000}
+
+	// 100 entries due per wake-up, only 60 fit in the queue at first
+	enqueued := 0
+	room := 0
+	enqueue := func() bool {
+		if room == 0 {
+			return false
+		}
+		room--
+		enqueued++
+		return true
+	}
+
+	room = 60
+	require.Zero(scheduler.dispatch(start.Add(time.Millisecond), enqueue, 200))
+	require.Equal(40, scheduler.backlog)
+	// The workers caught up, the backlog is delivered
+	room = 1000
+	require.Zero(scheduler.dispatch(start.Add(2*time.Millisecond), enqueue, 200))
+	require.Equal(200, enqueued)
+	require.Zero(scheduler.backlog)
+
+	// Entries are only dropped once the backlog overflows
+	room = 0
+	dropped := 0
+	for i := 3; i <= 5; i++ {
+		dropped += scheduler.dispatch(start.Add(time.Duration(i)*time.Millisecond), enqueue, 200)
+	}
+	require.Equal(100, dropped)
+	require.Equal(200, scheduler.backlog)
+}
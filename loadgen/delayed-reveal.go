@@ -0,0 +1,181 @@
+package loadgen
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulBernier/chockagent/journal"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// SubmitMode is how entries are committed and revealed.
+type SubmitMode string
+
+const (
+	// Reveal immediately after the commit
+	ModeCommitReveal SubmitMode = "commit-reveal"
+	// Never reveal
+	ModeCommitOnly SubmitMode = "commit-only"
+	// Reveal after SubmitConfig.RevealDelaySeconds or RevealDelayMinutes
+	ModeDelayedReveal SubmitMode = "delayed-reveal"
+
+	minutePollingInterval = time.Second
+	maxConcurrentReveals  = 100
+)
+
+type SubmitConfig struct {
+	Mode SubmitMode `mapstructure:"mode"`
+	// Delay in seconds or in factomd minutes, exclusively
+	RevealDelaySeconds float64 `mapstructure:"revealDelaySeconds"`
+	RevealDelayMinutes int     `mapstructure:"revealDelayMinutes"`
+}
+
+func (sc SubmitConfig) isValid() error {
+	switch sc.Mode {
+	case "", ModeCommitReveal, ModeCommitOnly:
+	case ModeDelayedReveal:
+		if sc.RevealDelaySeconds < 0 || sc.RevealDelayMinutes < 0 {
+			return fmt.Errorf("Negative reveal delay")
+		}
+		if (sc.RevealDelaySeconds > 0) == (sc.RevealDelayMinutes > 0) {
+			return fmt.Errorf("Exactly one of RevealDelaySeconds and RevealDelayMinutes must be set")
+		}
+	default:
+		return fmt.Errorf("Invalid mode [%s]", sc.Mode)
+	}
+	return nil
+}
+
+type delayedReveal struct {
+	reveal []byte
+	// Due time or factomd minute
+	dueTime   time.Time
+	dueMinute int
+}
+
+// scheduleReveal schedules the reveal of a copy of a committed entry.
+func (lg *LoadGenerator) scheduleReveal(reveal []byte) {
+	r := delayedReveal{reveal: append([]byte(nil), reveal...)}
+	if lg.submitConfig.RevealDelaySeconds > 0 {
+		r.dueTime = time.Now().Add(time.Duration(lg.submitConfig.RevealDelaySeconds * float64(time.Second)))
+	}
+
+	select {
+	case lg.delayedReveals <- r:
+	case <-lg.ctx.Done():
+		atomic.AddUint64(&lg.stats.droppedReveals, 1)
+	}
+}
+
+// runDelayedReveals reveals the committed entries once their delay is over.
+func (lg *LoadGenerator) runDelayedReveals() {
+	ticker := time.NewTicker(minutePollingInterval)
+	defer ticker.Stop()
+	sem := semaphore.NewWeighted(maxConcurrentReveals)
+
+	// Minutes elapsed since the genesis block
+	minutes := func() (int, error) {
+		height, minute, err := lg.factomd.Primary().CurrentBlockAndMinute(lg.ctx)
+		return height*10 + minute, err
+	}
+	// Reveals are only accepted once their due minute can be computed
+	var current int
+	incoming := lg.delayedReveals
+	if lg.submitConfig.RevealDelayMinutes > 0 {
+		var err error
+		if current, err = minutes(); err != nil {
+			log.WithError(err).Warn("Failed to fetch minute for delayed reveals")
+			incoming = nil
+		}
+	}
+	var pending []delayedReveal
+	finishing := lg.finishing
+
+	for {
+		select {
+		case <-lg.ctx.Done():
+			lg.dropReveals(len(pending))
+			return
+		case <-finishing:
+			// No more entries to reveal
+			finishing = nil
+		case r := <-incoming:
+			r.dueMinute = current + lg.submitConfig.RevealDelayMinutes
+			pending = append(pending, r)
+		case now := <-ticker.C:
+			if lg.submitConfig.RevealDelayMinutes > 0 {
+				m, err := minutes()
+				if err != nil {
+					log.WithError(err).Warn("Failed to fetch minute for delayed reveals")
+					continue
+				}
+				current = m
+				incoming = lg.delayedReveals
+			}
+
+			// All the reveals have the same delay, so they are due in order
+			due := 0
+			for due < len(pending) && pending[due].dueMinute <= current && !pending[due].dueTime.After(now) {
+				due++
+			}
+			for i, r := range pending[:due] {
+				if err := sem.Acquire(lg.ctx, 1); err != nil {
+					lg.dropReveals(len(pending) - i)
+					return
+				}
+				go func(reveal []byte) {
+					defer sem.Release(1)
+					start := time.Now()
+					err := lg.factomd.RevealEntry(lg.ctx, reveal)
+					if err == nil {
+						hash := computeEntryHash(reveal)
+						lg.ownEntries.add(hash[:])
+					}
+					if lg.ctx.Err() == nil {
+						lg.stats.delayedReveals.record(nil, err)
+						lg.journalReveal(reveal, time.Since(start), err)
+					}
+				}(r.reveal)
+			}
+			pending = append(pending[:0], pending[due:]...)
+
+			if finishing == nil && len(pending) == 0 && len(lg.delayedReveals) == 0 {
+				// Wait for the last reveals
+				sem.Acquire(lg.ctx, maxConcurrentReveals)
+				return
+			}
+		}
+	}
+}
+
+// dropReveals accounts for the reveals left when the load stops,
+// pending ones and those still queued.
+func (lg *LoadGenerator) dropReveals(pending int) {
+	// No more reveals are scheduled past that point
+	<-lg.finishing
+	atomic.AddUint64(&lg.stats.droppedReveals, uint64(pending+len(lg.delayedReveals)))
+}
+
+// journalReveal records the outcome of a delayed reveal.
+func (lg *LoadGenerator) journalReveal(reveal []byte, latency time.Duration, err error) {
+	if lg.journal == nil {
+		return
+	}
+
+	hash := computeEntryHash(reveal)
+	record := journal.Record{
+		Kind:      journal.Reveal,
+		EntryHash: hex.EncodeToString(hash[:]),
+		ChainID:   hex.EncodeToString(reveal[1:33]),
+		Latency:   milliseconds(latency),
+		Result:    journal.ResultAccepted,
+	}
+	if err != nil {
+		record.Result = journal.ResultError
+		record.Error = err.Error()
+	}
+	lg.journal.Write(record)
+}
@@ -0,0 +1,46 @@
+package loadgen
+
+import (
+	"context"
+	"time"
+
+	"github.com/PaulBernier/chockagent/factomd"
+)
+
+const (
+	DiagnosticsOnCommand   = "command"
+	DiagnosticsOnLoadStart = "load-start"
+	DiagnosticsOnLoadEnd   = "load-end"
+
+	diagnosticsTimeout = 10 * time.Second
+)
+
+// DiagnosticsPayload is the state of the factomd nodes
+// captured on command or at the start and end of a load.
+type DiagnosticsPayload struct {
+	Trigger string                `json:"trigger"`
+	JobID   string                `json:"jobId,omitempty"`
+	Nodes   []factomd.Diagnostics `json:"nodes"`
+}
+
+// CollectDiagnostics captures the state of the nodes of a cluster.
+func CollectDiagnostics(ctx context.Context, cluster *factomd.Cluster, trigger string, jobID string) DiagnosticsPayload {
+	payload := DiagnosticsPayload{Trigger: trigger, JobID: jobID, Nodes: cluster.CollectDiagnostics(ctx)}
+	for _, node := range payload.Nodes {
+		if len(node.Errors) > 0 {
+			log.WithField("endpoint", node.Endpoint).
+				WithField("errors", len(node.Errors)).
+				Warn("Failed to collect some diagnostics")
+		}
+	}
+	return payload
+}
+
+// emitDiagnostics collects and emits the diagnostics of the nodes of the load,
+// within diagnosticsTimeout.
+func (lg *LoadGenerator) emitDiagnostics(ctx context.Context, trigger string) {
+	ctx, cancel := context.WithTimeout(ctx, diagnosticsTimeout)
+	defer cancel()
+	payload := CollectDiagnostics(ctx, lg.factomd, trigger, lg.stats.jobID)
+	lg.emit(Event{Type: "diagnostics", Payload: payload})
+}
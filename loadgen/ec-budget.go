@@ -0,0 +1,132 @@
+package loadgen
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulBernier/chockagent/common"
+)
+
+const (
+	balanceCheckInterval = 10 * time.Second
+)
+
+var (
+	defaultECBalanceFloor uint64
+)
+
+func init() {
+	if os.Getenv("EC_BALANCE_FLOOR") != "" {
+		floor, err := strconv.ParseUint(os.Getenv("EC_BALANCE_FLOOR"), 10, 64)
+		if err != nil {
+			log.WithError(err).Fatalf("Failed to parse EC_BALANCE_FLOOR: [%s]",
+				os.Getenv("EC_BALANCE_FLOOR"))
+		}
+		defaultECBalanceFloor = floor
+	}
+}
+
+// ecBudget guards the EC balance of the address paying for a load.
+type ecBudget struct {
+	ecAddress string
+	floor     uint64
+	// Upper bound of the EC spent between two balance checks
+	costPerCheck uint64
+	// For a load of a known number of entries, the balance is checked
+	// against the cost of the entries left instead
+	nbEntries uint64
+	// See submissionCost
+	costPerSubmission float64
+}
+
+// remainingCost returns the cost left to spend once some entries were submitted.
+func (b ecBudget) remainingCost(submitted uint64) uint64 {
+	if b.nbEntries == 0 {
+		return b.costPerCheck
+	}
+	if submitted >= b.nbEntries {
+		return 0
+	}
+	return uint64(math.Ceil(float64(b.nbEntries-submitted) * b.costPerSubmission))
+}
+
+// maxEntryCost returns the cost of the largest entry that can be generated
+// for the given entry size range.
+func maxEntryCost(entrySizeRange common.IntRange) (uint64, error) {
+	cost, err := entryCost(entrySizeRange.Max + EntryHeaderSize)
+	return uint64(cost), err
+}
+
+// submissionCost returns the upper bound of the EC spent per submission,
+// given the cost of the largest valid entry. Invalid entries may cost more
+// than valid ones and replays pay for the same entries again.
+func submissionCost(costPerEntry uint64, invalid InvalidTrafficConfig, replay ReplayConfig) float64 {
+	kinds := invalid.Kinds
+	if len(kinds) == 0 {
+		kinds = invalidKinds
+	}
+	var invalidCost uint64
+	for _, kind := range kinds {
+		cost := costPerEntry
+		switch kind {
+		case Oversized:
+			cost = maxEntrySize/1024 + 1
+		case RevealOnly:
+			cost = 0
+		}
+		if cost > invalidCost {
+			invalidCost = cost
+		}
+	}
+
+	validCost := float64(costPerEntry) * (1 + replay.Ratio)
+	return (1-invalid.Ratio)*validCost + invalid.Ratio*float64(invalidCost)
+}
+
+// verify checks that spending plannedCost EC would not bring
+// the balance below the floor.
+func (b ecBudget) verify(balance uint64, plannedCost uint64) error {
+	if balance < b.floor || balance-b.floor < plannedCost {
+		return fmt.Errorf("EC balance of %s too low: balance [%d], planned cost [%d], floor [%d]",
+			b.ecAddress, balance, plannedCost, b.floor)
+	}
+
+	return nil
+}
+
+// monitorECBalance periodically checks the EC balance during the load
+// and aborts it before the balance goes below the floor.
+func (lg *LoadGenerator) monitorECBalance(budget ecBudget) {
+	ticker := time.NewTicker(balanceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lg.ctx.Done():
+			return
+		case <-ticker.C:
+			balance, err := lg.factomd.Primary().EntryCreditBalance(lg.ctx, budget.ecAddress)
+			// Transient failures are expected under heavy load
+			if err != nil {
+				log.WithError(err).Warn("Failed to check EC balance")
+				continue
+			}
+			atomic.StoreUint64(&lg.stats.lastBalance, balance)
+			// Submissions in progress may not be reflected in the balance yet
+			var done uint64
+			processed, busy := atomic.LoadUint64(&lg.pool.processed), uint64(atomic.LoadInt64(&lg.pool.busy))
+			if processed > busy {
+				done = processed - busy
+			}
+			cost := budget.remainingCost(done)
+			if err := budget.verify(balance, cost); err != nil {
+				lg.abort(err)
+				return
+			}
+		}
+	}
+}
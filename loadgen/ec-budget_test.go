@@ -0,0 +1,33 @@
+package loadgen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestECBudgetRemainingCost(t *testing.T) {
+	require := require.New(t)
+
+	constant := ecBudget{costPerCheck: 500}
+	require.EqualValues(500, constant.remainingCost(1000))
+
+	burst := ecBudget{nbEntries: 100, costPerSubmission: 2}
+	require.EqualValues(200, burst.remainingCost(0))
+	require.EqualValues(80, burst.remainingCost(60))
+	require.Zero(burst.remainingCost(100))
+	// A burst ending above the floor is not aborted part-way through
+	require.NoError(ecBudget{floor: 1000}.verify(1000+80, burst.remainingCost(60)))
+}
+
+func TestSubmissionCost(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(2.0, submissionCost(2, InvalidTrafficConfig{}, ReplayConfig{}))
+	// Half of the entries replayed
+	require.Equal(3.0, submissionCost(2, InvalidTrafficConfig{}, ReplayConfig{Ratio: 0.5}))
+	// Oversized entries pay for 11KB
+	require.InDelta(0.9*2+0.1*11, submissionCost(2, InvalidTrafficConfig{Ratio: 0.1}, ReplayConfig{}), 1e-9)
+	require.InDelta(0.9*2+0.1*2,
+		submissionCost(2, InvalidTrafficConfig{Ratio: 0.1, Kinds: []InvalidKind{CommitOnly}}, ReplayConfig{}), 1e-9)
+}
@@ -0,0 +1,127 @@
+package loadgen
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PaulBernier/chockagent/hostmetrics"
+)
+
+const (
+	// Samples kept for the report, the most recent ones
+	maxHostSamples = 720
+)
+
+var (
+	// Variable so that tests can sample faster
+	hostSamplingInterval = 10 * time.Second
+)
+
+// HostUsage summarizes the resource usage of the host during a load.
+type HostUsage struct {
+	PeakCPU        float64 `json:"peakCpu"`
+	MeanCPU        float64 `json:"meanCpu"`
+	PeakMemoryUsed uint64  `json:"peakMemoryUsed"`
+	// Peaks of the factomd process, if sampled
+	PeakProcessCPU float64              `json:"peakProcessCpu,omitempty"`
+	PeakProcessRSS uint64               `json:"peakProcessRss,omitempty"`
+	Samples        []hostmetrics.Sample `json:"samples"`
+}
+
+type hostRecorder struct {
+	mu      sync.Mutex
+	usage   HostUsage
+	count   int
+	cpuSum  float64
+	samples []hostmetrics.Sample
+}
+
+func (hr *hostRecorder) record(sample hostmetrics.Sample) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+
+	// The first sample has no rates
+	if len(hr.samples) > 0 {
+		hr.count++
+		hr.cpuSum += sample.CPU
+		if sample.CPU > hr.usage.PeakCPU {
+			hr.usage.PeakCPU = sample.CPU
+		}
+	}
+	if sample.MemoryUsed > hr.usage.PeakMemoryUsed {
+		hr.usage.PeakMemoryUsed = sample.MemoryUsed
+	}
+	if p := sample.Process; p != nil {
+		if p.CPU > hr.usage.PeakProcessCPU {
+			hr.usage.PeakProcessCPU = p.CPU
+		}
+		if p.RSS > hr.usage.PeakProcessRSS {
+			hr.usage.PeakProcessRSS = p.RSS
+		}
+	}
+
+	if len(hr.samples) == maxHostSamples {
+		hr.samples = append(hr.samples[:0], hr.samples[1:]...)
+	}
+	hr.samples = append(hr.samples, sample)
+}
+
+// last returns the most recent sample, nil if none.
+func (hr *hostRecorder) last() *hostmetrics.Sample {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if len(hr.samples) == 0 {
+		return nil
+	}
+	sample := hr.samples[len(hr.samples)-1]
+	return &sample
+}
+
+// summary returns the usage of the host, nil if never sampled.
+func (hr *hostRecorder) summary() *HostUsage {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	if len(hr.samples) == 0 {
+		return nil
+	}
+	usage := hr.usage
+	if hr.count > 0 {
+		usage.MeanCPU = hr.cpuSum / float64(hr.count)
+	}
+	usage.Samples = make([]hostmetrics.Sample, len(hr.samples))
+	copy(usage.Samples, hr.samples)
+	return &usage
+}
+
+// sampleHost samples the resource usage of the host until the load stops.
+func (lg *LoadGenerator) sampleHost() {
+	ticker := time.NewTicker(hostSamplingInterval)
+	defer ticker.Stop()
+
+	sampler := hostmetrics.NewSampler()
+	sample := func(now time.Time) bool {
+		s, err := sampler.Sample(now)
+		if err != nil {
+			log.WithError(err).Warn("Failed to sample host resource usage, sampling disabled")
+			return false
+		}
+		lg.stats.host.record(s)
+		return true
+	}
+
+	if !sample(time.Now()) {
+		return
+	}
+	for {
+		select {
+		case <-lg.ctx.Done():
+			// Cover the end of the load
+			sample(time.Now())
+			return
+		case now := <-ticker.C:
+			if !sample(now) {
+				return
+			}
+		}
+	}
+}
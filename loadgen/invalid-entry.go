@@ -0,0 +1,193 @@
+package loadgen
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulBernier/chockagent/journal"
+)
+
+// InvalidKind is a kind of deliberately invalid traffic.
+type InvalidKind string
+
+const (
+	BadSignature    InvalidKind = "bad-signature"
+	BadEntryHash    InvalidKind = "bad-entry-hash"
+	UnderstatedCost InvalidKind = "understated-cost"
+	BadTimestamp    InvalidKind = "bad-timestamp"
+	DuplicateCommit InvalidKind = "duplicate-commit"
+	Oversized       InvalidKind = "oversized"
+	RevealOnly      InvalidKind = "reveal-only"
+	CommitOnly      InvalidKind = "commit-only"
+
+	maxEntrySize = 10240
+	// Size of the signed part of an entry commit
+	commitSignedDataSize = 1 + 6 + 32 + 1
+	// Offset of the commit timestamps outside of the acceptance window of factomd
+	badTimestampOffset = 2 * time.Hour
+	// Smallest entry content costing more than 1 EC, for its cost to be understated
+	understatedCostMinSize = 1025
+)
+
+var invalidKinds = []InvalidKind{BadSignature, BadEntryHash, UnderstatedCost, BadTimestamp,
+	DuplicateCommit, Oversized, RevealOnly, CommitOnly}
+
+type InvalidTrafficConfig struct {
+	// Share of the submitted entries that are invalid
+	Ratio float64 `mapstructure:"ratio"`
+	// Kinds of invalid traffic generated, all of them if empty
+	Kinds []InvalidKind `mapstructure:"kinds"`
+}
+
+func (itc InvalidTrafficConfig) isValid() error {
+	if itc.Ratio < 0 || itc.Ratio > 1 {
+		return fmt.Errorf("Invalid ratio [%f]", itc.Ratio)
+	}
+	for _, kind := range itc.Kinds {
+		if !isInvalidKind(kind) {
+			return fmt.Errorf("Unknown kind [%s]", kind)
+		}
+	}
+	return nil
+}
+
+func isInvalidKind(kind InvalidKind) bool {
+	for _, k := range invalidKinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// ComposeInvalid composes an entry whose commit or reveal
+// should be rejected by factomd.
+func (comp *RandomEntryComposer) ComposeInvalid(kind InvalidKind) ([]byte, []byte, error) {
+	rng := comp.invalidRand()
+	defer comp.rngs.Put(rng)
+	return comp.composeInvalid(rng, kind)
+}
+
+func (comp *RandomEntryComposer) composeInvalid(rng *rand.Rand, kind InvalidKind) ([]byte, []byte, error) {
+	if kind == Oversized {
+		return comp.composeOversized(rng)
+	}
+
+	size := comp.entrySize(rng)
+	if kind == UnderstatedCost && size < understatedCostMinSize {
+		size = understatedCostMinSize
+	}
+
+	commit, reveal, err := comp.composeSized(rng, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch kind {
+	case BadSignature:
+		commit[len(commit)-1] ^= 0xff
+	case BadEntryHash:
+		reveal[len(reveal)-1] ^= 0xff
+	case UnderstatedCost:
+		commit[commitSignedDataSize-1]--
+		comp.sign(commit)
+	case BadTimestamp:
+		offset := badTimestampOffset
+		if rng.Intn(2) == 0 {
+			offset = -offset
+		}
+		putInt48BE(commit[1:], time.Now().Add(offset).Unix()*1e3)
+		comp.sign(commit)
+	}
+
+	return commit, reveal, nil
+}
+
+// composeOversized composes an entry larger than 10KB, with a commit paying for it.
+func (comp *RandomEntryComposer) composeOversized(rng *rand.Rand) ([]byte, []byte, error) {
+	content := make([]byte, maxEntrySize+1+rng.Intn(1024))
+	if _, err := rng.Read(content); err != nil {
+		return nil, nil, err
+	}
+
+	chainID := comp.chainIDs[rng.Intn(len(comp.chainIDs))]
+	reveal := entryBytes(chainID, content)
+	// Commit the largest valid prefix, then fix the hash and cost
+	commit := generateCommit(reveal[:EntryHeaderSize+maxEntrySize], comp.publicKey, comp.privateKey)
+	hash := computeEntryHash(reveal)
+	copy(commit[7:], hash[:])
+	commit[commitSignedDataSize-1] = maxEntrySize/1024 + 1
+	comp.sign(commit)
+
+	return commit, reveal, nil
+}
+
+// sign signs again a modified commit.
+func (comp *RandomEntryComposer) sign(commit []byte) {
+	sig := ed25519.Sign(comp.privateKey, commit[:commitSignedDataSize])
+	copy(commit[commitSignedDataSize+ed25519.PublicKeySize:], sig)
+}
+
+// nextInvalidEntry composes the next invalid entry, of a random kind.
+func (lg *LoadGenerator) nextInvalidEntry(composer *RandomEntryComposer) (InvalidKind, []byte, []byte, error) {
+	// The kind is drawn from the stream of the entry too
+	rng := composer.invalidRand()
+	defer composer.rngs.Put(rng)
+
+	kinds := lg.invalidTraffic.Kinds
+	if len(kinds) == 0 {
+		kinds = invalidKinds
+	}
+	kind := kinds[rng.Intn(len(kinds))]
+	commit, reveal, err := composer.composeInvalid(rng, kind)
+	return kind, commit, reveal, err
+}
+
+// submitInvalidEntry composes and submits a single invalid entry of a random kind.
+func (lg *LoadGenerator) submitInvalidEntry(composer *RandomEntryComposer) {
+	kind, commit, reveal, err := lg.nextInvalidEntry(composer)
+	// This should never happen, it's a hard failure
+	if err != nil {
+		atomic.AddUint64(&lg.stats.errors, 1)
+		log.WithError(err).Error("Fatal: failed to compose invalid entry")
+		return
+	}
+
+	start := time.Now()
+	var commitErr, revealErr error
+	switch kind {
+	case RevealOnly:
+		revealErr = lg.factomd.RevealEntry(lg.ctx, reveal)
+	case CommitOnly:
+		commitErr = lg.factomd.CommitEntry(lg.ctx, commit)
+	case DuplicateCommit:
+		if commitErr = lg.factomd.CommitEntry(lg.ctx, commit); commitErr != nil {
+			break
+		}
+		// The response to the duplicate is the one accounted for
+		commitErr = lg.factomd.CommitEntry(lg.ctx, commit)
+		revealErr = lg.factomd.RevealEntry(lg.ctx, reveal)
+	default:
+		if commitErr = lg.factomd.CommitEntry(lg.ctx, commit); commitErr == nil {
+			revealErr = lg.factomd.RevealEntry(lg.ctx, reveal)
+		}
+	}
+
+	latency := time.Since(start)
+
+	if lg.ctx.Err() != nil {
+		return
+	}
+	if commitErr == nil && kind != RevealOnly {
+		lg.stats.accountCommit(commit)
+	}
+	lg.stats.invalid[kind].record(commitErr, revealErr)
+	if lg.journal != nil {
+		record := submissionRecord(journal.InvalidSubmission, commit, reveal, latency)
+		record.Invalid = string(kind)
+		lg.journalTraffic(record, commitErr, revealErr, kind != CommitOnly)
+	}
+}
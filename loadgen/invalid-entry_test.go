@@ -0,0 +1,215 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/PaulBernier/chockagent/common"
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/journal"
+	"github.com/PaulBernier/chockagent/mockfactomd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidEntriesRejected(t *testing.T) {
+	require := require.New(t)
+
+	httpSrv := httptest.NewServer(mockfactomd.NewServer(mockfactomd.DefaultConfig()).Handler())
+	defer httpSrv.Close()
+	cli := factomd.NewClient(httpSrv.URL)
+	ctx := context.Background()
+
+	composer := newTestComposer(t, common.IntRange{Min: 2000, Max: 2000})
+
+	for _, kind := range []InvalidKind{BadSignature, BadEntryHash, UnderstatedCost, BadTimestamp, Oversized} {
+		commit, reveal, err := composer.ComposeInvalid(kind)
+		require.NoError(err)
+		require.Error(cli.CommitAndRevealEntry(ctx, commit, reveal), kind)
+	}
+
+	_, reveal, err := composer.ComposeInvalid(RevealOnly)
+	require.NoError(err)
+	require.Error(cli.RevealEntry(ctx, reveal))
+}
+
+func TestSeededInvalidComposition(t *testing.T) {
+	require := require.New(t)
+
+	compose := func(seed int64) ([][]byte, []float64) {
+		composer := newTestComposer(t, common.IntRange{Min: 32, Max: 2048}, chainIDStr, otherChainIDStr)
+		composer.SetSeed(seed)
+		var reveals [][]byte
+		var draws []float64
+		for i := 0; i < 10; i++ {
+			_, reveal, err := composer.ComposeInvalid(Oversized)
+			require.NoError(err)
+			reveals = append(reveals, reveal)
+			rng := composer.submissionRand()
+			draws = append(draws, rng.Float64())
+			composer.rngs.Put(rng)
+		}
+		return reveals, draws
+	}
+
+	reveals, draws := compose(42)
+	sameReveals, sameDraws := compose(42)
+	require.Equal(reveals, sameReveals)
+	require.Equal(draws, sameDraws)
+	otherReveals, otherDraws := compose(43)
+	require.NotEqual(reveals, otherReveals)
+	require.NotEqual(draws, otherDraws)
+}
+
+func TestUnderstatedCostOfSmallEntry(t *testing.T) {
+	require := require.New(t)
+
+	composer := newTestComposer(t, common.IntRange{Min: 32, Max: 100})
+
+	for i := 0; i < 10; i++ {
+		commit, reveal, err := composer.ComposeInvalid(UnderstatedCost)
+		require.NoError(err)
+		realCost, err := entryCost(len(reveal))
+		require.NoError(err)
+		cost := commit[commitSignedDataSize-1]
+		require.NotZero(cost)
+		require.Less(cost, realCost)
+	}
+}
+
+func TestInvalidEntriesIndependentOfOrder(t *testing.T) {
+	require := require.New(t)
+
+	lg := NewLoadGenerator(nil)
+	lg.invalidTraffic = InvalidTrafficConfig{Ratio: 0.5}
+	// Workers may compose the entries in another order than they drew their submissions
+	compose := func(reversed bool) []string {
+		composer := newTestComposer(t, common.IntRange{Min: 32, Max: 2048})
+		composer.SetSeed(42)
+
+		invalid := make([]bool, 50)
+		for i := range invalid {
+			rng := composer.submissionRand()
+			invalid[i] = rng.Float64() < lg.invalidTraffic.Ratio
+			composer.rngs.Put(rng)
+		}
+		var entries []string
+		for i := range invalid {
+			if reversed {
+				i = len(invalid) - 1 - i
+			}
+			var kind InvalidKind
+			var commit, reveal []byte
+			var err error
+			if invalid[i] {
+				kind, commit, reveal, err = lg.nextInvalidEntry(composer)
+			} else {
+				commit, reveal, err = composer.Compose()
+			}
+			require.NoError(err)
+			entries = append(entries, fmt.Sprintf("%s:%x:%x", kind, commit[7:commitSignedDataSize], reveal))
+		}
+		sort.Strings(entries)
+		return entries
+	}
+
+	require.Equal(compose(false), compose(true))
+}
+
+func TestSeededInvalidTraffic(t *testing.T) {
+	require := require.New(t)
+
+	// Entries submitted by a load of concurrent workers, regardless of their order
+	submit := func(seed int64) []string {
+		srv := mockfactomd.NewServer(mockfactomd.DefaultConfig())
+		var mu sync.Mutex
+		var submitted []string
+		httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			var req struct {
+				Method string
+				Params struct{ Message, Entry string }
+			}
+			json.Unmarshal(body, &req)
+			mu.Lock()
+			switch req.Method {
+			case "commit-entry":
+				// Entry hash and cost, the timestamp and signature vary
+				submitted = append(submitted, "commit:"+req.Params.Message[14:80])
+			case "reveal-entry":
+				submitted = append(submitted, "reveal:"+req.Params.Entry)
+			}
+			mu.Unlock()
+			srv.Mux().ServeHTTP(w, r)
+		}))
+		defer httpSrv.Close()
+		cluster, err := factomd.NewCluster([]string{httpSrv.URL + "/v2"}, factomd.RoundRobin)
+		require.NoError(err)
+
+		loadConfig := burstLoadConfig(100)
+		loadConfig.Seed = &seed
+		loadConfig.Workers = 8
+		loadConfig.InvalidTraffic = InvalidTrafficConfig{Ratio: 0.5}
+		lg := NewLoadGenerator(cluster)
+		require.NoError(lg.Run(loadConfig))
+		for range lg.Events() {
+		}
+
+		sort.Strings(submitted)
+		return submitted
+	}
+
+	submitted := submit(42)
+	require.Equal(submitted, submit(42))
+	require.NotEqual(submitted, submit(43))
+}
+
+func TestJournaledTraffic(t *testing.T) {
+	require := require.New(t)
+	_, cluster, stop := startMockFactomd(t, mockfactomd.DefaultConfig())
+	defer stop()
+	dir, err := ioutil.TempDir("", "journal")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	composer := newTestComposer(t, common.IntRange{Min: 100, Max: 100})
+	lg := NewLoadGenerator(cluster)
+	lg.stats = newStats()
+	lg.invalidTraffic = InvalidTrafficConfig{Ratio: 1, Kinds: []InvalidKind{BadSignature}}
+	lg.journal, err = journal.OpenIn(dir, "job", 1<<20)
+	require.NoError(err)
+
+	lg.submitInvalidEntry(composer)
+	commit, reveal, err := composer.Compose()
+	require.NoError(err)
+	commit, reveal = copyEntry(commit, reveal)
+	require.NoError(cluster.CommitAndRevealEntry(context.Background(), commit, reveal))
+	lg.replayEntry(replay{commit: commit, reveal: reveal})
+	require.NoError(lg.journal.Close())
+
+	files, err := journal.Files(dir, "job")
+	require.NoError(err)
+	records, err := journal.Read(files...)
+	require.NoError(err)
+	require.Len(records, 2)
+
+	require.Equal(journal.InvalidSubmission, records[0].Kind)
+	require.Equal(string(BadSignature), records[0].Invalid)
+	require.Equal(journal.ResultError, records[0].Result)
+	require.Contains(records[0].Error, "signature")
+
+	require.Equal(journal.Replay, records[1].Kind)
+	require.Equal(hex.EncodeToString(commit[7:39]), records[1].EntryHash)
+	require.Equal(journal.ResultError, records[1].Result)
+	require.Contains(records[1].Error, "Repeated Commit")
+}
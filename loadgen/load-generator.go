@@ -1,10 +1,16 @@
 package loadgen
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/PaulBernier/chockagent/common"
 	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/journal"
 	_log "github.com/PaulBernier/chockagent/log"
 
 	"github.com/Factom-Asset-Tokens/factom"
@@ -13,10 +19,63 @@ import (
 
 var (
 	log = _log.GetLog()
+	// Load types supported by Run
+	LoadTypes = []string{"constant", "burst"}
 )
 
+// Event is emitted by the LoadGenerator to be relayed to the coordinator.
+type Event struct {
+	Type    string
+	Payload interface{}
+}
+
+type ReasonPayload struct {
+	Reason string `json:"reason"`
+}
+
 type LoadGenerator struct {
-	stop chan interface{}
+	factomd *factomd.Cluster
+	// Cancelled when the load stops, which also cancels in-flight RPCs
+	ctx    context.Context
+	halt   context.CancelFunc
+	done   chan struct{}
+	events chan Event
+	// Tracks the goroutines running alongside the load
+	wg sync.WaitGroup
+	// Closed once all the entries are submitted, the load is then over
+	// when the pending work (delayed reveals, replays) is complete
+	finishing chan struct{}
+	pending   sync.WaitGroup
+	stats     *Stats
+	// Share of the submitted entries probed for propagation
+	propagationSampleRate float64
+	// Number of ongoing propagation probes
+	probes         int64
+	invalidTraffic InvalidTrafficConfig
+	replay         ReplayConfig
+	replays        chan replay
+	submitConfig   SubmitConfig
+	delayedReveals chan delayedReveal
+	pool           *workerPool
+	// Entries composed before the load, if any
+	precomposed chan composedEntry
+	// Records the submitted entries, nil if journals are disabled
+	journal *journal.Writer
+	// Own entries not yet seen in a block
+	ownEntries *entrySet
+	// Holds the submission of new entries while paused
+	gate pauseGate
+
+	reportConfig ReportConfig
+	startHeight  int
+	// Outcome of the load, set when stopped or aborted
+	mu          sync.Mutex
+	outcome     string
+	abortReason string
+	// Serializes the events emitted
+	emitMu sync.Mutex
+	// Set once the events channel is closed, guarded by emitMu
+	eventsClosed bool
 }
 
 type LoadConfig struct {
@@ -24,14 +83,46 @@ type LoadConfig struct {
 	ChainIDsStr    []string
 	EsAddressStr   string
 	EntrySizeRange common.IntRange
-	Params         map[string]interface{}
+	ECBalanceFloor uint64
+	// Share of the entries probed for propagation latency, default if nil
+	PropagationSampleRate *float64
+	InvalidTraffic        InvalidTrafficConfig
+	Replay                ReplayConfig
+	Submit                SubmitConfig
+	// Number of concurrent submissions
+	Workers int
+	// Seed of the entries composed, random if nil
+	Seed *int64
+	// Identifies the load in the journal, generated if empty
+	JobID  string
+	Params map[string]interface{}
 }
 
-func NewLoadGenerator() *LoadGenerator {
+// propagationSampleRate returns the share of the entries probed,
+// 0 disabling the probes.
+func (config LoadConfig) propagationSampleRate() float64 {
+	if config.PropagationSampleRate == nil {
+		return defaultPropagationSampleRate
+	}
+	return *config.PropagationSampleRate
+}
+
+func NewLoadGenerator(cluster *factomd.Cluster) *LoadGenerator {
 	gen := new(LoadGenerator)
+	gen.factomd = cluster
+	gen.ctx, gen.halt = context.WithCancel(context.Background())
+	gen.done = make(chan struct{})
+	gen.finishing = make(chan struct{})
+	gen.events = make(chan Event, 64)
 	return gen
 }
 
+// Events returns the channel of events emitted by the load.
+// The channel is closed once the load is over.
+func (lg *LoadGenerator) Events() <-chan Event {
+	return lg.events
+}
+
 func (lg *LoadGenerator) Run(config LoadConfig) error {
 	esAddress, err := factom.NewEsAddress(config.EsAddressStr)
 	if err != nil {
@@ -42,13 +133,19 @@ func (lg *LoadGenerator) Run(config LoadConfig) error {
 	if err != nil {
 		return err
 	}
+	if config.Seed != nil {
+		composer.SetSeed(*config.Seed)
+	}
 
 	log.WithField("load-type", config.Type).
 		WithField("entry-size-range", config.EntrySizeRange).
 		WithField("nb-chains", len(config.ChainIDsStr)).
+		WithField("nb-nodes", len(lg.factomd.Nodes)).
+		WithField("endpoint-policy", lg.factomd.Policy).
+		WithField("seed", composer.Seed()).
 		Info("General load config parsed")
 
-	height, minute, err := factomd.CurrentBlockAndMinute()
+	height, minute, err := lg.factomd.Primary().CurrentBlockAndMinute(lg.ctx)
 	if err != nil {
 		return err
 	}
@@ -56,17 +153,47 @@ func (lg *LoadGenerator) Run(config LoadConfig) error {
 		WithField("minute", minute).
 		Info("Current factomd state")
 
+	if err := config.InvalidTraffic.isValid(); err != nil {
+		return fmt.Errorf("Invalid InvalidTrafficConfig: %s", err)
+	}
+	lg.invalidTraffic = config.InvalidTraffic
+	if err := config.Replay.isValid(); err != nil {
+		return fmt.Errorf("Invalid ReplayConfig: %s", err)
+	}
+	lg.replay = config.Replay
+	if err := config.Submit.isValid(); err != nil {
+		return fmt.Errorf("Invalid SubmitConfig: %s", err)
+	}
+	lg.submitConfig = config.Submit
+	if config.Workers < 0 {
+		return fmt.Errorf("Invalid number of workers [%d]", config.Workers)
+	}
+
+	costPerEntry, err := maxEntryCost(config.EntrySizeRange)
+	if err != nil {
+		return err
+	}
+	costPerSubmission := submissionCost(costPerEntry, config.InvalidTraffic, config.Replay)
+	budget := ecBudget{ecAddress: esAddress.ECAddress().String(), floor: config.ECBalanceFloor}
+	if budget.floor == 0 {
+		budget.floor = defaultECBalanceFloor
+	}
+
+	var run func()
+	var plannedCost uint64
 	switch config.Type {
 	case "constant":
-		lg.stop = make(chan interface{})
-
 		var clc ConstantLoadConfig
 		mapstructure.Decode(config.Params, &clc)
 		if err := clc.isValid(); err != nil {
 			return fmt.Errorf("Invalid ConstantLoadConfig: %s", err)
 		}
 
-		go lg.runConstantLoad(clc, composer)
+		// A constant load has no planned end, only make sure
+		// it can run until the next balance check
+		budget.costPerCheck = uint64(math.Ceil((clc.EPS*balanceCheckInterval.Seconds() + 1) * costPerSubmission))
+		plannedCost = budget.costPerCheck
+		run = func() { lg.runConstantLoad(clc) }
 	case "burst":
 		var blc BurstLoadConfig
 		mapstructure.Decode(config.Params, &blc)
@@ -74,19 +201,197 @@ func (lg *LoadGenerator) Run(config LoadConfig) error {
 			return fmt.Errorf("Invalid BurstLoadConfig: %s", err)
 		}
 
-		go lg.runBurstLoad(blc, composer)
+		budget.nbEntries, budget.costPerSubmission = uint64(blc.NbEntries), costPerSubmission
+		plannedCost = budget.remainingCost(0)
+		run = func() { lg.runBurstLoad(blc, composer) }
 	default:
 		return fmt.Errorf("Non supported load type: [%s]", config.Type)
 	}
 
+	balance, err := lg.factomd.Primary().EntryCreditBalance(lg.ctx, budget.ecAddress)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch EC balance: %s", err)
+	}
+	if err := budget.verify(balance, plannedCost); err != nil {
+		return err
+	}
+	log.WithField("balance", balance).
+		WithField("planned-cost", plannedCost).
+		WithField("floor", budget.floor).
+		Info("EC balance verified")
+
+	lg.propagationSampleRate = config.propagationSampleRate()
+	jobID := config.JobID
+	if jobID == "" {
+		jobID = fmt.Sprintf("%s-%s", config.Type, time.Now().UTC().Format("20060102-150405"))
+	}
+	// It names the journal and the report files
+	if err := journal.ValidateJobID(jobID); err != nil {
+		return err
+	}
+	lg.journal, err = journal.Open(jobID)
+	if err != nil {
+		return fmt.Errorf("Failed to open journal: %s", err)
+	}
+	if lg.journal != nil {
+		log.WithField("job-id", jobID).
+			WithField("dir", journal.Dir()).
+			Info("Journal opened")
+		lg.journal.Write(journal.Record{Kind: journal.Start, Height: height})
+	}
+
+	lg.stats = newStats()
+	lg.stats.seed = composer.Seed()
+	lg.stats.jobID = jobID
+	lg.stats.initialBalance = balance
+	lg.stats.lastBalance = balance
+
+	workers := config.Workers
+	if workers == 0 {
+		workers = defaultWorkers
+	}
+	lg.pool = newWorkerPool(workers, lg.ctx.Done(), func() { lg.submitEntry(composer) })
+	lg.reportConfig = newReportConfig(config, workers)
+	lg.startHeight = height
+	lg.stats.queue = lg.pool
+
+	if lg.replay.Ratio > 0 {
+		lg.replays = make(chan replay, 1000)
+		lg.pending.Add(1)
+		go func() {
+			defer lg.pending.Done()
+			lg.runReplays()
+		}()
+	}
+
+	if lg.submitConfig.Mode == ModeDelayedReveal {
+		lg.delayedReveals = make(chan delayedReveal, 10000)
+		lg.pending.Add(1)
+		go func() {
+			defer lg.pending.Done()
+			lg.runDelayedReveals()
+		}()
+	}
+
+	lg.ownEntries = newEntrySet()
+
+	go func() {
+		// State of the nodes before any entry is submitted, collected
+		// here for Run not to hold the caller
+		lg.emitDiagnostics(lg.ctx, DiagnosticsOnLoadStart)
+		lg.stats.start = time.Now()
+
+		lg.wg.Add(4)
+		go func() {
+			defer lg.wg.Done()
+			lg.monitorECBalance(budget)
+		}()
+		go func() {
+			defer lg.wg.Done()
+			lg.observeBlocks(config.ChainIDsStr)
+		}()
+		go func() {
+			defer lg.wg.Done()
+			lg.reportStats()
+		}()
+		go func() {
+			defer lg.wg.Done()
+			lg.sampleHost()
+		}()
+
+		run()
+		lg.pool.stop()
+		close(lg.finishing)
+		lg.pending.Wait()
+		lg.halt()
+		lg.wg.Wait()
+
+		endHeight, _, err := lg.factomd.Primary().CurrentBlockAndMinute(context.Background())
+		if err != nil {
+			log.WithError(err).Warn("Failed to fetch the height at the end of the load")
+		}
+		// For the EC spent to account for the whole load
+		balance, err := lg.factomd.Primary().EntryCreditBalance(context.Background(), budget.ecAddress)
+		if err != nil {
+			log.WithError(err).Warn("Failed to fetch the EC balance at the end of the load")
+		} else {
+			atomic.StoreUint64(&lg.stats.lastBalance, balance)
+		}
+		lg.closeJournal(endHeight)
+		lg.emitDiagnostics(context.Background(), DiagnosticsOnLoadEnd)
+		report := lg.report(endHeight)
+		if err := writeReport(report); err != nil {
+			log.WithError(err).Error("Failed to write load report")
+		}
+		// Room is always left for the report, see emit
+		lg.emitMu.Lock()
+		lg.events <- Event{Type: "load-report", Payload: report}
+		close(lg.events)
+		lg.eventsClosed = true
+		lg.emitMu.Unlock()
+		close(lg.done)
+	}()
+
 	return nil
 }
 
+// closeJournal records the end of the load and closes the journal.
+func (lg *LoadGenerator) closeJournal(endHeight int) {
+	if lg.journal == nil {
+		return
+	}
+
+	if endHeight > 0 {
+		lg.journal.Write(journal.Record{Kind: journal.End, Height: endHeight})
+	}
+	if err := lg.journal.Close(); err != nil {
+		log.WithError(err).Error("Failed to close journal")
+	}
+}
+
+// Stop stops the load and waits for it to be over.
 func (lg *LoadGenerator) Stop() {
-	if lg.stop != nil {
-		log.Info("Stopping load...")
-		lg.stop <- true
-	} else {
+	if lg.over() {
 		log.Warn("Load already stopped by itself")
+		return
+	}
+
+	log.Info("Stopping load...")
+	lg.setOutcome(OutcomeStopped, "")
+	lg.halt()
+	<-lg.done
+}
+
+// abort stops the load and reports the reason to the coordinator.
+func (lg *LoadGenerator) abort(reason error) {
+	log.WithError(reason).Error("Aborting load")
+	lg.setOutcome(OutcomeAborted, reason.Error())
+	lg.emit(Event{Type: "load-aborted", Payload: ReasonPayload{Reason: reason.Error()}})
+	lg.halt()
+}
+
+// setOutcome sets the outcome of the load, unless already set.
+func (lg *LoadGenerator) setOutcome(outcome string, abortReason string) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	if lg.outcome == "" {
+		lg.outcome = outcome
+		lg.abortReason = abortReason
+	}
+}
+
+// emit sends an event without blocking, always leaving room
+// in the channel for the final report. Events are discarded once the load is over.
+func (lg *LoadGenerator) emit(event Event) {
+	lg.emitMu.Lock()
+	defer lg.emitMu.Unlock()
+
+	if lg.eventsClosed {
+		return
+	}
+	if len(lg.events) >= cap(lg.events)-1 {
+		log.WithField("type", event.Type).Warn("Event dropped, events channel full")
+		return
 	}
+	lg.events <- event
 }
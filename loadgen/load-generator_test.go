@@ -0,0 +1,336 @@
+package loadgen
+
+import (
+	"crypto/ed25519"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PaulBernier/chockagent/common"
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/mockfactomd"
+	"github.com/stretchr/testify/require"
+)
+
+func startMockFactomd(t *testing.T, config mockfactomd.Config) (*mockfactomd.Server, *factomd.Cluster, func()) {
+	srv := mockfactomd.NewServer(config)
+	httpSrv := httptest.NewServer(srv.Mux())
+	cluster, err := factomd.NewCluster([]string{httpSrv.URL + "/v2"}, factomd.RoundRobin)
+	require.NoError(t, err)
+	return srv, cluster, httpSrv.Close
+}
+
+func burstLoadConfig(nbEntries int) LoadConfig {
+	return LoadConfig{
+		Type:           "burst",
+		ChainIDsStr:    []string{chainIDStr},
+		EsAddressStr:   esAddressStr,
+		EntrySizeRange: common.IntRange{Min: 100, Max: 2000},
+		Params:         map[string]interface{}{"nbEntries": nbEntries},
+	}
+}
+
+func TestBurstLoad(t *testing.T) {
+	require := require.New(t)
+	srv, cluster, stop := startMockFactomd(t, mockfactomd.DefaultConfig())
+	defer stop()
+
+	lg := NewLoadGenerator(cluster)
+	require.NoError(lg.Run(burstLoadConfig(100)))
+
+	// Collected before the start of the submissions
+	first := <-lg.Events()
+	require.Equal("diagnostics", first.Type)
+	diagnostics := []DiagnosticsPayload{first.Payload.(DiagnosticsPayload)}
+
+	// Wait for the end of the load
+	var last Event
+	for event := range lg.Events() {
+		last = event
+		if event.Type == "diagnostics" {
+			diagnostics = append(diagnostics, event.Payload.(DiagnosticsPayload))
+		}
+	}
+
+	counters := srv.Counters()
+	require.EqualValues(100, counters.Commits)
+	require.EqualValues(100, counters.Reveals)
+	require.EqualValues(0, counters.Rejected)
+
+	require.Equal("load-report", last.Type)
+	report := last.Payload.(LoadReport)
+	require.Equal(OutcomeFinished, report.Outcome)
+	require.EqualValues(100, report.Stats.Submitted)
+	require.Equal("burst", report.Config.Type)
+
+	if runtime.GOOS == "linux" {
+		require.NotNil(report.Host)
+		require.NotEmpty(report.Host.Samples)
+	}
+
+	require.Len(diagnostics, 2)
+	require.Equal(DiagnosticsOnLoadStart, diagnostics[0].Trigger)
+	require.Equal(DiagnosticsOnLoadEnd, diagnostics[1].Trigger)
+	for _, diag := range diagnostics {
+		require.Len(diag.Nodes, 1)
+		require.Empty(diag.Nodes[0].Errors)
+		require.Equal(mockfactomd.Version, diag.Nodes[0].Properties.FactomdVersion)
+		require.Contains(diag.Nodes[0].Debug, "process-list")
+	}
+}
+
+func TestStoppedLoadReport(t *testing.T) {
+	require := require.New(t)
+	_, cluster, stop := startMockFactomd(t, mockfactomd.DefaultConfig())
+	defer stop()
+
+	loadConfig := burstLoadConfig(0)
+	loadConfig.Type = "constant"
+	loadConfig.Params = map[string]interface{}{"eps": 0.01}
+	lg := NewLoadGenerator(cluster)
+	require.NoError(lg.Run(loadConfig))
+	lg.Stop()
+
+	var last Event
+	for event := range lg.Events() {
+		last = event
+	}
+	require.Equal("load-report", last.Type)
+	report := last.Payload.(LoadReport)
+	require.Equal(OutcomeStopped, report.Outcome)
+	require.Zero(report.Stats.Submitted)
+}
+
+func TestBurstLoadRefusedOnLowBalance(t *testing.T) {
+	require := require.New(t)
+	config := mockfactomd.DefaultConfig()
+	config.ECBalance = 150
+	srv, cluster, stop := startMockFactomd(t, config)
+	defer stop()
+
+	loadConfig := burstLoadConfig(100)
+	loadConfig.ECBalanceFloor = 100
+	lg := NewLoadGenerator(cluster)
+	require.Error(lg.Run(loadConfig))
+	require.EqualValues(0, srv.Counters().Commits)
+}
+
+func TestDelayedReveal(t *testing.T) {
+	require := require.New(t)
+	srv, cluster, stop := startMockFactomd(t, mockfactomd.DefaultConfig())
+	defer stop()
+
+	loadConfig := burstLoadConfig(10)
+	loadConfig.Submit = SubmitConfig{Mode: ModeDelayedReveal, RevealDelaySeconds: 1}
+	lg := NewLoadGenerator(cluster)
+	require.NoError(lg.Run(loadConfig))
+
+	require.Eventually(func() bool { return srv.Counters().Commits == 10 }, time.Second, 10*time.Millisecond)
+	require.EqualValues(0, srv.Counters().Reveals)
+	require.Eventually(func() bool { return srv.Counters().Reveals == 10 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDelayedRevealsDroppedOnStop(t *testing.T) {
+	require := require.New(t)
+	srv, cluster, stop := startMockFactomd(t, mockfactomd.DefaultConfig())
+	defer stop()
+
+	loadConfig := burstLoadConfig(10)
+	loadConfig.Submit = SubmitConfig{Mode: ModeDelayedReveal, RevealDelaySeconds: 60}
+	lg := NewLoadGenerator(cluster)
+	require.NoError(lg.Run(loadConfig))
+
+	// All the reveals are scheduled
+	require.Eventually(func() bool {
+		return atomic.LoadUint64(&lg.pool.processed) == 10 && atomic.LoadInt64(&lg.pool.busy) == 0
+	}, time.Second, 10*time.Millisecond)
+	lg.Stop()
+
+	var last Event
+	for event := range lg.Events() {
+		last = event
+	}
+	require.Equal("load-report", last.Type)
+	stats := last.Payload.(LoadReport).Stats
+	require.Nil(stats.DelayedReveals)
+	require.EqualValues(10, stats.DroppedReveals)
+	require.Zero(srv.Counters().Reveals)
+}
+
+func TestPrecomposedBurstLoad(t *testing.T) {
+	require := require.New(t)
+	srv, cluster, stop := startMockFactomd(t, mockfactomd.DefaultConfig())
+	defer stop()
+
+	loadConfig := burstLoadConfig(100)
+	loadConfig.Params["precompose"] = true
+	lg := NewLoadGenerator(cluster)
+	require.NoError(lg.Run(loadConfig))
+
+	var last Event
+	for event := range lg.Events() {
+		last = event
+	}
+
+	counters := srv.Counters()
+	require.EqualValues(100, counters.Commits)
+	require.EqualValues(100, counters.Reveals)
+	require.EqualValues(0, counters.Rejected)
+
+	// Reported apart from the duration of the submissions
+	stats := last.Payload.(LoadReport).Stats
+	require.NotZero(stats.PrecomposeDuration)
+	require.Greater(stats.Duration, 0.0)
+}
+
+func TestStalePrecomposedEntryIsSignedAgain(t *testing.T) {
+	require := require.New(t)
+	config := burstLoadConfig(1)
+	composer := newTestComposer(t, config.EntrySizeRange)
+
+	lg := NewLoadGenerator(nil)
+	lg.stats = newStats()
+	require.True(lg.precompose(composer, 1))
+	entry := <-lg.precomposed
+	stale := append([]byte(nil), entry.commit...)
+	entry.composedAt = time.Now().Add(-precomposedMaxAge - time.Minute)
+	lg.precomposed = make(chan composedEntry, 1)
+	lg.precomposed <- entry
+
+	commit, _, err := lg.nextEntry(composer)
+	require.NoError(err)
+	require.EqualValues(1, lg.stats.resigned)
+	require.Equal(stale[7:commitSignedDataSize], commit[7:commitSignedDataSize])
+	require.True(ed25519.Verify(composer.publicKey, commit[:commitSignedDataSize],
+		commit[commitSignedDataSize+ed25519.PublicKeySize:]))
+}
+
+func TestBlockObservation(t *testing.T) {
+	require := require.New(t)
+	config := mockfactomd.DefaultConfig()
+	config.MinuteDuration = 5 * time.Millisecond
+	_, cluster, stop := startMockFactomd(t, config)
+	defer stop()
+	defer func(interval time.Duration) { blockPollingInterval = interval }(blockPollingInterval)
+	blockPollingInterval = 20 * time.Millisecond
+
+	loadConfig := burstLoadConfig(0)
+	loadConfig.Type = "constant"
+	loadConfig.Params = map[string]interface{}{"eps": 50}
+	lg := NewLoadGenerator(cluster)
+	require.NoError(lg.Run(loadConfig))
+
+	ownEntries := 0
+	timeout := time.After(5 * time.Second)
+	for ownEntries < 10 {
+		select {
+		case event := <-lg.Events():
+			if event.Type == "block-stats" {
+				bs := event.Payload.(BlockStats)
+				ownEntries += bs.OwnEntries
+				require.Equal(bs.OwnEntries, bs.Entries)
+			}
+		case <-timeout:
+			require.FailNow("Entries not observed in blocks")
+		}
+	}
+	lg.Stop()
+}
+
+func TestPausedLoad(t *testing.T) {
+	require := require.New(t)
+	srv, cluster, stop := startMockFactomd(t, mockfactomd.DefaultConfig())
+	defer stop()
+
+	loadConfig := burstLoadConfig(0)
+	loadConfig.Type = "constant"
+	loadConfig.Params = map[string]interface{}{"eps": 100}
+	lg := NewLoadGenerator(cluster)
+	lg.Pause("test")
+	require.NoError(lg.Run(loadConfig))
+
+	time.Sleep(200 * time.Millisecond)
+	require.Zero(srv.Counters().Commits)
+
+	lg.Resume()
+	require.Eventually(func() bool { return srv.Counters().Commits > 0 }, 5*time.Second, 10*time.Millisecond)
+	lg.Stop()
+
+	var types []string
+	for event := range lg.Events() {
+		types = append(types, event.Type)
+	}
+	require.Contains(types, "load-paused")
+	require.Contains(types, "load-resumed")
+}
+
+func TestPauseFinishedLoad(t *testing.T) {
+	require := require.New(t)
+	_, cluster, stop := startMockFactomd(t, mockfactomd.DefaultConfig())
+	defer stop()
+
+	lg := NewLoadGenerator(cluster)
+	require.NoError(lg.Run(burstLoadConfig(1)))
+	for range lg.Events() {
+	}
+
+	// The agent may pause a load whose end it has not seen yet
+	lg.Pause("test")
+	require.False(lg.gate.paused())
+	lg.Resume()
+	lg.emit(Event{Type: "load-stats"})
+}
+
+func TestPropagationSampleRate(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(defaultPropagationSampleRate, LoadConfig{}.propagationSampleRate())
+	disabled := 0.0
+	require.Zero(LoadConfig{PropagationSampleRate: &disabled}.propagationSampleRate())
+}
+
+func TestReplay(t *testing.T) {
+	defer func(interval time.Duration) { replayPollingInterval = interval }(replayPollingInterval)
+	replayPollingInterval = 10 * time.Millisecond
+
+	for _, delay := range []ReplayDelay{SameBlock, NextBlock} {
+		t.Run(string(delay), func(t *testing.T) {
+			require := require.New(t)
+			config := mockfactomd.DefaultConfig()
+			config.MinuteDuration = 5 * time.Millisecond
+			srv, cluster, stop := startMockFactomd(t, config)
+			defer stop()
+
+			loadConfig := burstLoadConfig(20)
+			loadConfig.Replay = ReplayConfig{Ratio: 1, Delay: delay}
+			lg := NewLoadGenerator(cluster)
+			require.NoError(lg.Run(loadConfig))
+
+			var last Event
+			for event := range lg.Events() {
+				last = event
+			}
+			require.Equal("load-report", last.Type)
+			stats := last.Payload.(LoadReport).Stats
+
+			// Every replay is rejected as a repeated commit
+			counters := srv.Counters()
+			require.EqualValues(20, counters.Commits)
+			require.EqualValues(20, counters.Rejected)
+			require.NotNil(stats.Replays)
+			require.EqualValues(20, stats.Replays.Submitted)
+			require.EqualValues(20, stats.Replays.CommitRejected)
+			require.Len(stats.Replays.Errors, 1)
+			for msg := range stats.Replays.Errors {
+				require.Contains(msg, "Repeated Commit")
+			}
+
+			// Hence only the first commits are paid for
+			require.Zero(stats.ReplayedCost)
+			require.NotZero(stats.ExpectedECSpent)
+			require.Equal(stats.ExpectedECSpent, stats.ECSpent)
+		})
+	}
+}
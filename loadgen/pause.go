@@ -0,0 +1,98 @@
+package loadgen
+
+import (
+	"sync"
+)
+
+// pauseGate holds the load schedulers while paused.
+// Pending work (delayed reveals, replays) goes on while paused.
+type pauseGate struct {
+	mu sync.Mutex
+	// Closed on resume, nil when not paused
+	resumed chan struct{}
+}
+
+var running = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// pause returns false if already paused.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		return false
+	}
+	g.resumed = make(chan struct{})
+	return true
+}
+
+// resume returns false if not paused.
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		return false
+	}
+	close(g.resumed)
+	g.resumed = nil
+	return true
+}
+
+// wait returns a channel closed once the load is not paused.
+func (g *pauseGate) wait() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		return running
+	}
+	return g.resumed
+}
+
+func (g *pauseGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// over returns true once the load is over.
+func (lg *LoadGenerator) over() bool {
+	select {
+	case <-lg.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Pause holds the submission of new entries until Resume is called.
+// It has no effect once the load is over.
+func (lg *LoadGenerator) Pause(reason string) {
+	if lg.over() || !lg.gate.pause() {
+		return
+	}
+	log.WithField("reason", reason).Warn("Load paused")
+	lg.emit(Event{Type: "load-paused", Payload: ReasonPayload{Reason: reason}})
+}
+
+// Resume resumes a paused load.
+func (lg *LoadGenerator) Resume() {
+	if lg.over() || !lg.gate.resume() {
+		return
+	}
+	log.Info("Load resumed")
+	lg.emit(Event{Type: "load-resumed"})
+}
+
+// waitResumed waits for the load to be resumed if paused.
+// It returns false if the load stopped first.
+func (lg *LoadGenerator) waitResumed() bool {
+	select {
+	case <-lg.gate.wait():
+		return true
+	case <-lg.ctx.Done():
+		return false
+	}
+}
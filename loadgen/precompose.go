@@ -0,0 +1,83 @@
+package loadgen
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Maximum number of entries composed before a burst, the rest is composed inline
+	maxPrecomposed = 100000
+	// Commits older than that are signed again with a fresh timestamp
+	// to stay well within the acceptance window of factomd (1 hour)
+	precomposedMaxAge = 30 * time.Minute
+)
+
+type composedEntry struct {
+	commit     []byte
+	reveal     []byte
+	composedAt time.Time
+}
+
+// precompose composes and signs n entries using all the CPU cores,
+// so that signing does not slow down the submission.
+// It returns false if the load was stopped in the meantime.
+func (lg *LoadGenerator) precompose(composer *RandomEntryComposer, n int) bool {
+	if n > maxPrecomposed {
+		n = maxPrecomposed
+	}
+	entries := make(chan composedEntry, n)
+
+	var wg sync.WaitGroup
+	var failed uint64
+	workers := runtime.NumCPU()
+	for w := 0; w < workers; w++ {
+		// Spread the remainder over the first workers
+		share := n / workers
+		if w < n%workers {
+			share++
+		}
+
+		wg.Add(1)
+		go func(share int) {
+			defer wg.Done()
+			for i := 0; i < share && lg.ctx.Err() == nil; i++ {
+				commit, reveal, err := composer.Compose()
+				if err != nil {
+					atomic.AddUint64(&failed, 1)
+					continue
+				}
+				// Held until submitted, so not in a pooled buffer
+				kept, keptReveal := copyEntry(commit, reveal)
+				composer.Release(commit, reveal)
+				entries <- composedEntry{commit: kept, reveal: keptReveal, composedAt: time.Now()}
+			}
+		}(share)
+	}
+	wg.Wait()
+	close(entries)
+
+	if failed > 0 {
+		log.WithField("failed", failed).Error("Fatal: failed to compose entries")
+	}
+	lg.precomposed = entries
+	return lg.ctx.Err() == nil
+}
+
+// nextEntry returns a precomposed entry if any is left, otherwise composes one.
+func (lg *LoadGenerator) nextEntry(composer *RandomEntryComposer) ([]byte, []byte, error) {
+	if lg.precomposed != nil {
+		if entry, ok := <-lg.precomposed; ok {
+			if time.Since(entry.composedAt) > precomposedMaxAge {
+				putInt48BE(entry.commit[1:], time.Now().Unix()*1e3)
+				composer.sign(entry.commit)
+				atomic.AddUint64(&lg.stats.resigned, 1)
+			}
+			return entry.commit, entry.reveal, nil
+		}
+	}
+
+	return composer.Compose()
+}
@@ -8,6 +8,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/PaulBernier/chockagent/common"
@@ -27,11 +29,46 @@ const (
 		2 // total len
 )
 
+const (
+	// Buffers are pooled by classes of content size of bufferClassSize,
+	// so that a small entry does not hold a buffer sized for the largest one
+	bufferClassSize = 1024
+	nbBufferClasses = maxEntrySize / bufferClassSize
+)
+
+// bufferClass returns the class of the buffers holding an entry content of size bytes.
+func bufferClass(size int) int {
+	if size <= 0 {
+		return 0
+	}
+	return (size - 1) / bufferClassSize
+}
+
+// bufferSize returns the size of the buffers of a class, holding both
+// the commit and the reveal of an entry.
+func bufferSize(class int) int {
+	return EntryCommitSize + EntryHeaderSize + (class+1)*bufferClassSize
+}
+
 type RandomEntryComposer struct {
-	chainIDs           [][]byte
-	privateKey         ed25519.PrivateKey
-	publicKey          ed25519.PublicKey
-	entrySizeGenerator func() int
+	chainIDs   [][]byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+	entrySize  func(rng *rand.Rand) int
+	// Each entry is composed from its own seed, derived from the seed
+	// of the composer and the index of the entry
+	seed  int64
+	index uint64
+	// Index of the next submission, from which its traffic decisions
+	// are drawn, see submissionRand
+	submissions uint64
+	// Index of the next invalid entry, see invalidRand
+	invalids uint64
+	// Random sources, so that concurrent workers do not contend
+	// on the lock of the global source
+	rngs sync.Pool
+	// Buffers released after submission by class, see Release
+	buffers [nbBufferClasses]sync.Pool
 }
 
 func NewRandomEntryComposer(chainIDsStr []string,
@@ -56,48 +93,149 @@ func NewRandomEntryComposer(chainIDsStr []string,
 	if entrySizeRange.Min < 32 || entrySizeRange.Min > entrySizeRange.Max {
 		return nil, fmt.Errorf("Invalid entry size range: [%+v]", entrySizeRange)
 	}
+	if entrySizeRange.Max > maxEntrySize {
+		return nil, fmt.Errorf("Entry size range above the maximum entry size [%d]: [%+v]",
+			maxEntrySize, entrySizeRange)
+	}
 
 	if entrySizeRange.Min == entrySizeRange.Max {
-		comp.entrySizeGenerator = func() int { return entrySizeRange.Min }
+		comp.entrySize = func(*rand.Rand) int { return entrySizeRange.Min }
 	} else {
-		comp.entrySizeGenerator = func() int {
-			return entrySizeRange.Min + rand.Intn(entrySizeRange.Max-entrySizeRange.Min)
+		comp.entrySize = func(rng *rand.Rand) int {
+			return entrySizeRange.Min + rng.Intn(entrySizeRange.Max-entrySizeRange.Min)
+		}
+	}
+
+	// Exactly representable by a JSON number
+	comp.seed = rand.Int63n(1 << 53)
+	comp.rngs.New = func() interface{} {
+		return rand.New(new(splitMix64))
+	}
+	for class := range comp.buffers {
+		size := bufferSize(class)
+		comp.buffers[class].New = func() interface{} {
+			buf := make([]byte, size)
+			return &buf
 		}
 	}
 
 	return comp, nil
 }
 
+// SetSeed sets the seed from which the entries are composed. The same seed
+// produces the same sizes, chains and contents for the n-th composed entry.
+// It must be called before composing any entry.
+func (comp *RandomEntryComposer) SetSeed(seed int64) {
+	comp.seed = seed
+}
+
+func (comp *RandomEntryComposer) Seed() int64 {
+	return comp.seed
+}
+
+// Compose composes a random entry and its signed commit.
+// The commit and the reveal are pooled, see Release.
 func (comp *RandomEntryComposer) Compose() ([]byte, []byte, error) {
-	content := make([]byte, comp.entrySizeGenerator())
-	_, err := rand.Read(content)
-	if err != nil {
+	rng := comp.entryRand()
+	defer comp.rngs.Put(rng)
+	return comp.compose(rng)
+}
+
+// entryRand returns the random source of the next entry, to give back to comp.rngs.
+func (comp *RandomEntryComposer) entryRand() *rand.Rand {
+	rng := comp.rngs.Get().(*rand.Rand)
+	rng.Seed(entrySeed(comp.seed, atomic.AddUint64(&comp.index, 1)-1))
+	return rng
+}
+
+// submissionRand returns the random source of the traffic decisions
+// of the next submission, to give back to comp.rngs. It is drawn from
+// a stream apart from the entries, so that the decisions do not shift
+// the contents of the entries.
+func (comp *RandomEntryComposer) submissionRand() *rand.Rand {
+	rng := comp.rngs.Get().(*rand.Rand)
+	rng.Seed(entrySeed(^comp.seed, atomic.AddUint64(&comp.submissions, 1)-1))
+	return rng
+}
+
+// invalidRand returns the random source of the next invalid entry, to give
+// back to comp.rngs. Invalid entries are drawn from their own stream, so that
+// the n-th invalid entry is the same whichever order workers compose entries in.
+func (comp *RandomEntryComposer) invalidRand() *rand.Rand {
+	rng := comp.rngs.Get().(*rand.Rand)
+	rng.Seed(entrySeed(int64(mix64(uint64(comp.seed))), atomic.AddUint64(&comp.invalids, 1)-1))
+	return rng
+}
+
+func (comp *RandomEntryComposer) compose(rng *rand.Rand) ([]byte, []byte, error) {
+	return comp.composeSized(rng, comp.entrySize(rng))
+}
+
+// composeSized composes a random entry of a content of size bytes.
+func (comp *RandomEntryComposer) composeSized(rng *rand.Rand, size int) ([]byte, []byte, error) {
+	if _, err := entryCost(EntryHeaderSize + size); err != nil {
 		return nil, nil, err
 	}
 
-	chainID := comp.chainIDs[rand.Intn(len(comp.chainIDs))]
-	reveal := entryBytes(chainID, content)
-	commit := generateCommit(reveal, comp.publicKey, comp.privateKey)
+	// The commit and the reveal share the same buffer
+	buf := *comp.buffers[bufferClass(size)].Get().(*[]byte)
+	commit := buf[:EntryCommitSize]
+	reveal := buf[EntryCommitSize : EntryCommitSize+EntryHeaderSize+size]
+
+	if _, err := rng.Read(reveal[EntryHeaderSize:]); err != nil {
+		comp.Release(commit, reveal)
+		return nil, nil, err
+	}
+	putEntryHeader(reveal, comp.chainIDs[rng.Intn(len(comp.chainIDs))])
+	writeCommit(commit, reveal, comp.publicKey, comp.privateKey)
 
 	return commit, reveal, nil
 }
 
+// Release gives back the buffers of an entry returned by Compose
+// once it is submitted. Neither the commit nor the reveal can be used afterwards.
+func (comp *RandomEntryComposer) Release(commit, reveal []byte) {
+	buf := commit[:cap(commit)]
+	class := bufferClass(len(reveal) - EntryHeaderSize)
+	if len(buf) == bufferSize(class) {
+		comp.buffers[class].Put(&buf)
+	}
+}
+
+// copyEntry returns a copy of an entry in a buffer of its size,
+// for entries kept beyond their submission.
+func copyEntry(commit, reveal []byte) ([]byte, []byte) {
+	buf := make([]byte, len(commit)+len(reveal))
+	copy(buf, commit)
+	copy(buf[len(commit):], reveal)
+	return buf[:len(commit):len(commit)], buf[len(commit):]
+}
+
 func entryBytes(chainID, content []byte) []byte {
 	data := make([]byte, len(content)+EntryHeaderSize)
+	putEntryHeader(data, chainID)
+	copy(data[EntryHeaderSize:], content)
+
+	return data
+}
+
+// putEntryHeader writes the header of an entry without external IDs.
+func putEntryHeader(data []byte, chainID []byte) {
+	data[0] = 0 // Version
 	i := 1
 	i += copy(data[i:], chainID[:])
 	binary.BigEndian.PutUint16(data[i:i+2], uint16(0))
-	i += 2
-
-	copy(data[i:], content)
-
-	return data
 }
 
 func generateCommit(entrydata []byte, publicKey ed25519.PublicKey, privateKey ed25519.PrivateKey) []byte {
 	commit := make([]byte, EntryCommitSize)
+	writeCommit(commit, entrydata, publicKey, privateKey)
+	return commit
+}
 
-	i := 1 // Skip version byte
+func writeCommit(commit []byte, entrydata []byte, publicKey ed25519.PublicKey, privateKey ed25519.PrivateKey) {
+	commit[0] = 0 // Version
+	i := 1
 
 	ms := time.Now().Unix() * 1e3
 	putInt48BE(commit[i:], ms)
@@ -124,16 +262,28 @@ func generateCommit(entrydata []byte, publicKey ed25519.PublicKey, privateKey ed
 	// Signature
 	sig := ed25519.Sign(privateKey, commit[:signedDataSize])
 	copy(commit[i:], sig)
-
-	return commit
 }
 
+// Buffers for the salted data hashed by computeEntryHash
+var saltedPool = sync.Pool{New: func() interface{} {
+	buf := make([]byte, sha512.Size+EntryHeaderSize+maxEntrySize)
+	return &buf
+}}
+
+// computeEntryHash returns sha256(sha512(data) | data).
 func computeEntryHash(data []byte) [32]byte {
 	sum := sha512.Sum512(data)
-	saltedSum := make([]byte, len(sum)+len(data))
+
+	p := saltedPool.Get().(*[]byte)
+	defer saltedPool.Put(p)
+	saltedSum := *p
+	if len(sum)+len(data) > len(saltedSum) {
+		// Oversized entries
+		saltedSum = make([]byte, len(sum)+len(data))
+	}
 	i := copy(saltedSum, sum[:])
-	copy(saltedSum[i:], data)
-	return sha256.Sum256(saltedSum)
+	i += copy(saltedSum[i:], data)
+	return sha256.Sum256(saltedSum[:i])
 }
 
 func putInt48BE(data []byte, x int64) {
@@ -1,6 +1,10 @@
 package loadgen
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/Factom-Asset-Tokens/factom"
@@ -8,16 +12,31 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-const ENTRY_HEADER_LENGTH = 35
+const (
+	ENTRY_HEADER_LENGTH = 35
+	esAddressStr        = "Es3ytEKt6R5jM9juC4ks7EgxQSX8BpRnM4WADtgFoq7j1WgbEEGW"
+	chainIDStr          = "2d98021e3cf71580102224b2fcb4c5c60595e8fdf6fd1b97c6ef63e9fb3ed635"
+	otherChainIDStr     = "bf7ab8c5e5d1e1d5de59da3b3e27a1e9dcb0c7fe6e4b1feeafa6d6c5c1d5b0f2"
+)
+
+// newTestComposer returns a composer of entries of the given size range
+// in the test chain, or in the given chains.
+func newTestComposer(t testing.TB, entrySizeRange common.IntRange, chainIDs ...string) *RandomEntryComposer {
+	if len(chainIDs) == 0 {
+		chainIDs = []string{chainIDStr}
+	}
+	esAddress, err := factom.NewEsAddress(esAddressStr)
+	require.NoError(t, err)
+	composer, err := NewRandomEntryComposer(chainIDs, esAddress, entrySizeRange)
+	require.NoError(t, err)
+	return composer
+}
 
 func TestExactEntryLength(t *testing.T) {
 	require := require.New(t)
 
-	esAddress, _ := factom.NewEsAddress("Es3ytEKt6R5jM9juC4ks7EgxQSX8BpRnM4WADtgFoq7j1WgbEEGW")
-
 	size := 1024
-	composer, err := NewRandomEntryComposer(
-		[]string{"2d98021e3cf71580102224b2fcb4c5c60595e8fdf6fd1b97c6ef63e9fb3ed635"}, esAddress, common.IntRange{Min: size, Max: size})
+	composer := newTestComposer(t, common.IntRange{Min: size, Max: size})
 
 	_, reveal, err := composer.Compose()
 
@@ -28,12 +47,9 @@ func TestExactEntryLength(t *testing.T) {
 func TestRangeEntryLength(t *testing.T) {
 	require := require.New(t)
 
-	esAddress, _ := factom.NewEsAddress("Es3ytEKt6R5jM9juC4ks7EgxQSX8BpRnM4WADtgFoq7j1WgbEEGW")
-
 	min := 1024
 	max := 2048
-	composer, err := NewRandomEntryComposer(
-		[]string{"2d98021e3cf71580102224b2fcb4c5c60595e8fdf6fd1b97c6ef63e9fb3ed635"}, esAddress, common.IntRange{Min: min, Max: max})
+	composer := newTestComposer(t, common.IntRange{Min: min, Max: max})
 
 	_, reveal, err := composer.Compose()
 
@@ -41,3 +57,113 @@ func TestRangeEntryLength(t *testing.T) {
 	require.GreaterOrEqual(len(reveal), ENTRY_HEADER_LENGTH+min)
 	require.LessOrEqual(len(reveal), ENTRY_HEADER_LENGTH+max)
 }
+
+func TestOversizedEntry(t *testing.T) {
+	require := require.New(t)
+
+	esAddress, _ := factom.NewEsAddress(esAddressStr)
+	_, err := NewRandomEntryComposer([]string{chainIDStr}, esAddress, common.IntRange{Min: 32, Max: maxEntrySize + 1})
+	require.Error(err)
+
+	composer := newTestComposer(t, common.IntRange{Min: maxEntrySize, Max: maxEntrySize})
+	_, _, err = composer.Compose()
+	require.NoError(err)
+	// Only invalid traffic composes larger entries
+	_, _, err = composer.composeSized(composer.entryRand(), maxEntrySize+1)
+	require.EqualError(err, "Entry cannot be larger than 10KB")
+}
+
+func TestCopyEntry(t *testing.T) {
+	require := require.New(t)
+
+	composer := newTestComposer(t, common.IntRange{Min: 100, Max: 100})
+
+	commit, reveal, err := composer.Compose()
+	require.NoError(err)
+	require.Equal(bufferSize(0), cap(commit))
+
+	kept, keptReveal := copyEntry(commit, reveal)
+	require.Equal(commit, kept)
+	require.Equal(reveal, keptReveal)
+	require.Equal(len(commit)+len(reveal), cap(kept)+cap(keptReveal))
+	// Copies are not pooled
+	composer.Release(commit, reveal)
+	composer.Release(kept, keptReveal)
+}
+
+func TestSeededComposition(t *testing.T) {
+	require := require.New(t)
+
+	compose := func(seed int64) [][]byte {
+		composer := newTestComposer(t, common.IntRange{Min: 32, Max: 2048}, chainIDStr, otherChainIDStr)
+		composer.SetSeed(seed)
+		var reveals [][]byte
+		for i := 0; i < 10; i++ {
+			_, reveal, err := composer.Compose()
+			require.NoError(err)
+			reveals = append(reveals, reveal)
+		}
+		return reveals
+	}
+
+	require.Equal(compose(42), compose(42))
+	require.NotEqual(compose(42), compose(43))
+}
+
+func TestComputeEntryHash(t *testing.T) {
+	require := require.New(t)
+
+	for _, size := range []int{0, 1024, maxEntrySize + EntryHeaderSize + 1} {
+		data := make([]byte, size)
+		rand.Read(data)
+		sum := sha512.Sum512(data)
+		expected := sha256.Sum256(append(sum[:], data...))
+		require.Equal(expected, computeEntryHash(data))
+	}
+}
+
+var benchmarkEntrySizes = []int{32, 1024, 10240}
+
+func BenchmarkCompose(b *testing.B) {
+	for _, size := range benchmarkEntrySizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			composer := newTestComposer(b, common.IntRange{Min: size, Max: size})
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					commit, reveal, err := composer.Compose()
+					if err != nil {
+						b.Fatal(err)
+					}
+					composer.Release(commit, reveal)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkComputeEntryHash(b *testing.B) {
+	for _, size := range benchmarkEntrySizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			data := make([]byte, size+EntryHeaderSize)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				computeEntryHash(data)
+			}
+		})
+	}
+}
+
+func BenchmarkGenerateCommit(b *testing.B) {
+	for _, size := range benchmarkEntrySizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			composer := newTestComposer(b, common.IntRange{Min: size, Max: size})
+			data := make([]byte, size+EntryHeaderSize)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				generateCommit(data, composer.publicKey, composer.privateKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package loadgen
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulBernier/chockagent/journal"
+)
+
+// ReplayDelay is when previously submitted entries are replayed.
+type ReplayDelay string
+
+const (
+	SameBlock ReplayDelay = "same-block"
+	NextBlock ReplayDelay = "next-block"
+	// After ReplayConfig.Blocks blocks
+	LaterBlock ReplayDelay = "blocks"
+
+	// Maximum number of entries waiting to be replayed
+	maxPendingReplays = 100000
+)
+
+var (
+	replayPollingInterval = 5 * time.Second
+)
+
+type ReplayConfig struct {
+	// Share of the successfully submitted entries replayed
+	Ratio  float64     `mapstructure:"ratio"`
+	Delay  ReplayDelay `mapstructure:"delay"`
+	Blocks int         `mapstructure:"blocks"`
+}
+
+func (rc ReplayConfig) isValid() error {
+	if rc.Ratio < 0 || rc.Ratio > 1 {
+		return fmt.Errorf("Invalid ratio [%f]", rc.Ratio)
+	}
+	if rc.Ratio == 0 {
+		return nil
+	}
+
+	switch rc.Delay {
+	case SameBlock, NextBlock:
+	case LaterBlock:
+		if rc.Blocks < 1 {
+			return fmt.Errorf("Invalid number of blocks [%d]", rc.Blocks)
+		}
+	default:
+		return fmt.Errorf("Invalid delay [%s]", rc.Delay)
+	}
+	return nil
+}
+
+// blocks returns the number of blocks to wait before replaying an entry.
+func (rc ReplayConfig) blocks() int {
+	switch rc.Delay {
+	case NextBlock:
+		return 1
+	case LaterBlock:
+		return rc.Blocks
+	}
+	return 0
+}
+
+type replay struct {
+	commit []byte
+	reveal []byte
+	height int
+}
+
+// captureReplay schedules a copy of an already submitted entry to be replayed.
+func (lg *LoadGenerator) captureReplay(commit []byte, reveal []byte, rng *rand.Rand) {
+	if lg.replay.Ratio == 0 || rng.Float64() >= lg.replay.Ratio {
+		return
+	}
+
+	commit, reveal = copyEntry(commit, reveal)
+	select {
+	case lg.replays <- replay{commit: commit, reveal: reveal}:
+	default:
+		// Replayer lagging behind, skip that entry
+	}
+}
+
+// runReplays replays the captured entries once their delay is over.
+func (lg *LoadGenerator) runReplays() {
+	ticker := time.NewTicker(replayPollingInterval)
+	defer ticker.Stop()
+
+	height, _, err := lg.factomd.Primary().CurrentBlockAndMinute(lg.ctx)
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch height for replays")
+	}
+	var pending []replay
+	finishing := lg.finishing
+
+	for {
+		select {
+		case <-lg.ctx.Done():
+			return
+		case <-finishing:
+			// No more entries to capture
+			finishing = nil
+		case r := <-lg.replays:
+			if len(pending) < maxPendingReplays {
+				r.height = height
+				pending = append(pending, r)
+			}
+		case <-ticker.C:
+			h, _, err := lg.factomd.Primary().CurrentBlockAndMinute(lg.ctx)
+			if err != nil {
+				log.WithError(err).Warn("Failed to fetch height for replays")
+				continue
+			}
+			height = h
+
+			// Entries are captured in order, so are their heights
+			due := 0
+			for due < len(pending) && pending[due].height+lg.replay.blocks() <= height {
+				due++
+			}
+			for _, r := range pending[:due] {
+				lg.replayEntry(r)
+			}
+			pending = append(pending[:0], pending[due:]...)
+
+			if finishing == nil && len(pending) == 0 && len(lg.replays) == 0 {
+				return
+			}
+		}
+	}
+}
+
+func (lg *LoadGenerator) replayEntry(r replay) {
+	start := time.Now()
+	commitErr := lg.factomd.CommitEntry(lg.ctx, r.commit)
+	var revealErr error
+	if commitErr == nil {
+		atomic.AddUint64(&lg.stats.replayedCost, uint64(r.commit[commitSignedDataSize-1]))
+		revealErr = lg.factomd.RevealEntry(lg.ctx, r.reveal)
+	}
+	latency := time.Since(start)
+
+	if lg.ctx.Err() != nil {
+		return
+	}
+	lg.stats.replays.record(commitErr, revealErr)
+	if lg.journal != nil {
+		lg.journalTraffic(submissionRecord(journal.Replay, r.commit, r.reveal, latency), commitErr, revealErr, true)
+	}
+}
@@ -0,0 +1,116 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PaulBernier/chockagent/common"
+	"github.com/PaulBernier/chockagent/journal"
+)
+
+const (
+	OutcomeFinished = "finished"
+	OutcomeStopped  = "stopped"
+	OutcomeAborted  = "aborted"
+)
+
+var (
+	reportDir = filepath.Join(os.TempDir(), "chockagent-reports")
+)
+
+func init() {
+	if os.Getenv("REPORT_DIR") != "" {
+		reportDir = os.Getenv("REPORT_DIR")
+	}
+}
+
+// ReportConfig is the config of a load, without its secrets.
+type ReportConfig struct {
+	Type                  string                 `json:"type"`
+	ChainIDs              []string               `json:"chainIds"`
+	EntrySizeRange        common.IntRange        `json:"entrySizeRange"`
+	ECBalanceFloor        uint64                 `json:"ecBalanceFloor"`
+	PropagationSampleRate float64                `json:"propagationSampleRate"`
+	InvalidTraffic        InvalidTrafficConfig   `json:"invalidTraffic"`
+	Replay                ReplayConfig           `json:"replay"`
+	Submit                SubmitConfig           `json:"submit"`
+	Workers               int                    `json:"workers"`
+	Params                map[string]interface{} `json:"params"`
+}
+
+func newReportConfig(config LoadConfig, workers int) ReportConfig {
+	return ReportConfig{
+		Type:                  config.Type,
+		ChainIDs:              config.ChainIDsStr,
+		EntrySizeRange:        config.EntrySizeRange,
+		ECBalanceFloor:        config.ECBalanceFloor,
+		PropagationSampleRate: config.propagationSampleRate(),
+		InvalidTraffic:        config.InvalidTraffic,
+		Replay:                config.Replay,
+		Submit:                config.Submit,
+		Workers:               workers,
+		Params:                config.Params,
+	}
+}
+
+// LoadReport summarizes a load once it is over.
+type LoadReport struct {
+	Config ReportConfig `json:"config"`
+	// Wall times in seconds since epoch
+	Start       int64 `json:"start"`
+	End         int64 `json:"end"`
+	StartHeight int   `json:"startHeight"`
+	// 0 if it could not be fetched
+	EndHeight int `json:"endHeight"`
+	// Finished, stopped or aborted
+	Outcome     string        `json:"outcome"`
+	AbortReason string        `json:"abortReason,omitempty"`
+	Stats       StatsSnapshot `json:"stats"`
+	// Resource usage of the host during the load
+	Host *HostUsage `json:"host,omitempty"`
+}
+
+// report returns the report of the load once over.
+func (lg *LoadGenerator) report(endHeight int) LoadReport {
+	lg.mu.Lock()
+	outcome, reason := lg.outcome, lg.abortReason
+	lg.mu.Unlock()
+	if outcome == "" {
+		outcome = OutcomeFinished
+	}
+
+	return LoadReport{
+		Config:      lg.reportConfig,
+		Start:       lg.stats.start.Unix(),
+		End:         time.Now().Unix(),
+		StartHeight: lg.startHeight,
+		EndHeight:   endHeight,
+		Outcome:     outcome,
+		AbortReason: reason,
+		Stats:       lg.stats.snapshot(),
+		Host:        lg.stats.host.summary(),
+	}
+}
+
+// writeReport writes the report in REPORT_DIR, named after the job ID.
+func writeReport(report LoadReport) error {
+	if err := journal.ValidateJobID(report.Stats.JobID); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(reportDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(reportDir, report.Stats.JobID+".report.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	log.WithField("path", path).Info("Load report written")
+	return nil
+}
@@ -0,0 +1,32 @@
+package loadgen
+
+// splitMix64 is a fast random source that can be seeded for each entry
+// at no cost, unlike the default source of math/rand.
+type splitMix64 struct {
+	state uint64
+}
+
+func (sm *splitMix64) Seed(seed int64) {
+	sm.state = uint64(seed)
+}
+
+func (sm *splitMix64) Uint64() uint64 {
+	sm.state += 0x9e3779b97f4a7c15
+	return mix64(sm.state)
+}
+
+func (sm *splitMix64) Int63() int64 {
+	return int64(sm.Uint64() >> 1)
+}
+
+// mix64 is the finalizer of SplitMix64, it scrambles the bits of x.
+func mix64(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// entrySeed derives the seed of the i-th entry of a load.
+func entrySeed(seed int64, i uint64) int64 {
+	return int64(mix64(uint64(seed) ^ mix64(i)))
+}
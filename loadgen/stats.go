@@ -0,0 +1,340 @@
+package loadgen
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulBernier/chockagent/hostmetrics"
+)
+
+const (
+	// Number of samples kept to estimate a latency distribution
+	reservoirSize = 10000
+	// Maximum number of distinct error messages counted by a responseRecorder
+	maxErrorMessages = 20
+)
+
+// latencyRecorder estimates a latency distribution
+// from a uniform sample of the recorded latencies.
+type latencyRecorder struct {
+	mu        sync.Mutex
+	count     uint64
+	sum       time.Duration
+	max       time.Duration
+	reservoir []time.Duration
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{reservoir: make([]time.Duration, 0, reservoirSize)}
+}
+
+func (lr *latencyRecorder) record(latency time.Duration) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	lr.count++
+	lr.sum += latency
+	if latency > lr.max {
+		lr.max = latency
+	}
+
+	// Reservoir sampling
+	if len(lr.reservoir) < reservoirSize {
+		lr.reservoir = append(lr.reservoir, latency)
+	} else if i := rand.Int63n(int64(lr.count)); i < reservoirSize {
+		lr.reservoir[i] = latency
+	}
+}
+
+// LatencyDistribution is expressed in milliseconds.
+type LatencyDistribution struct {
+	Count uint64  `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+func (lr *latencyRecorder) distribution() LatencyDistribution {
+	lr.mu.Lock()
+	samples := make([]time.Duration, len(lr.reservoir))
+	copy(samples, lr.reservoir)
+	dist := LatencyDistribution{Count: lr.count, Max: milliseconds(lr.max)}
+	if lr.count > 0 {
+		dist.Mean = milliseconds(lr.sum) / float64(lr.count)
+	}
+	lr.mu.Unlock()
+
+	if len(samples) == 0 {
+		return dist
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(samples)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return milliseconds(samples[i])
+	}
+	dist.P50 = percentile(0.50)
+	dist.P90 = percentile(0.90)
+	dist.P99 = percentile(0.99)
+
+	return dist
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ResponseStats counts how factomd responds to unusual submissions.
+type ResponseStats struct {
+	Submitted      uint64            `json:"submitted"`
+	CommitRejected uint64            `json:"commitRejected"`
+	RevealRejected uint64            `json:"revealRejected"`
+	Accepted       uint64            `json:"accepted"`
+	Errors         map[string]uint64 `json:"errors"`
+}
+
+type responseRecorder struct {
+	mu    sync.Mutex
+	stats ResponseStats
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{stats: ResponseStats{Errors: make(map[string]uint64)}}
+}
+
+func (rr *responseRecorder) record(commitErr, revealErr error) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	rr.stats.Submitted++
+	err := commitErr
+	switch {
+	case commitErr != nil:
+		rr.stats.CommitRejected++
+	case revealErr != nil:
+		rr.stats.RevealRejected++
+		err = revealErr
+	default:
+		rr.stats.Accepted++
+		return
+	}
+
+	if _, ok := rr.stats.Errors[err.Error()]; ok || len(rr.stats.Errors) < maxErrorMessages {
+		rr.stats.Errors[err.Error()]++
+	}
+}
+
+func (rr *responseRecorder) snapshot() ResponseStats {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	snapshot := rr.stats
+	snapshot.Errors = make(map[string]uint64, len(rr.stats.Errors))
+	for msg, count := range rr.stats.Errors {
+		snapshot.Errors[msg] = count
+	}
+	return snapshot
+}
+
+// errorCounter counts errors by message, up to maxErrorMessages distinct messages.
+type errorCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func newErrorCounter() *errorCounter {
+	return &errorCounter{counts: make(map[string]uint64)}
+}
+
+func (ec *errorCounter) record(err error) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if _, ok := ec.counts[err.Error()]; ok || len(ec.counts) < maxErrorMessages {
+		ec.counts[err.Error()]++
+	}
+}
+
+func (ec *errorCounter) snapshot() map[string]uint64 {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if len(ec.counts) == 0 {
+		return nil
+	}
+	snapshot := make(map[string]uint64, len(ec.counts))
+	for msg, count := range ec.counts {
+		snapshot[msg] = count
+	}
+	return snapshot
+}
+
+// accountCommit adds the cost of an accepted commit to the expected EC spend.
+func (s *Stats) accountCommit(commit []byte) {
+	atomic.AddUint64(&s.expectedSpend, uint64(commit[commitSignedDataSize-1]))
+}
+
+// Stats of a load, safe for concurrent use.
+type Stats struct {
+	start time.Time
+	jobID string
+	// Seed of the composed entries, to reproduce the load
+	seed      int64
+	submitted uint64
+	errors    uint64
+	// Submission errors by message
+	errorMessages *errorCounter
+	submit        *latencyRecorder
+	propagation   *latencyRecorder
+	// Entries never seen by the probe node
+	unpropagated uint64
+	invalid      map[InvalidKind]*responseRecorder
+	replays      *responseRecorder
+	// Reveals of the delayed reveal mode
+	delayedReveals *responseRecorder
+	// Delayed reveals dropped when the load stops
+	droppedReveals uint64
+	// EC accounting
+	initialBalance uint64
+	lastBalance    uint64
+	expectedSpend  uint64
+	replayedCost   uint64
+	// Submit queue of the load, if started
+	queue *workerPool
+	// Precomposed entries signed again because too old
+	resigned uint64
+	// Time spent composing the entries before the load, in ns
+	precomposeDuration int64
+	// Throughput observed in the blocks
+	blocks *blockRecorder
+	// Resource usage of the host
+	host *hostRecorder
+	// Rate of a constant load, as float64 bits
+	targetEPS uint64
+}
+
+func newStats() *Stats {
+	stats := &Stats{
+		start:          time.Now(),
+		errorMessages:  newErrorCounter(),
+		blocks:         new(blockRecorder),
+		host:           new(hostRecorder),
+		submit:         newLatencyRecorder(),
+		propagation:    newLatencyRecorder(),
+		invalid:        make(map[InvalidKind]*responseRecorder),
+		replays:        newResponseRecorder(),
+		delayedReveals: newResponseRecorder(),
+	}
+	for _, kind := range invalidKinds {
+		stats.invalid[kind] = newResponseRecorder()
+	}
+	return stats
+}
+
+type StatsSnapshot struct {
+	JobID              string              `json:"jobId"`
+	Seed               int64               `json:"seed"`
+	Duration           float64             `json:"duration"`
+	Submitted          uint64              `json:"submitted"`
+	Errors             uint64              `json:"errors"`
+	ErrorMessages      map[string]uint64   `json:"errorMessages,omitempty"`
+	SubmitLatency      LatencyDistribution `json:"submitLatency"`
+	PropagationLatency LatencyDistribution `json:"propagationLatency"`
+	Unpropagated       uint64              `json:"unpropagated"`
+	// Responses to the invalid traffic by kind
+	Invalid map[InvalidKind]ResponseStats `json:"invalid,omitempty"`
+	// Responses to the replayed entries
+	Replays *ResponseStats `json:"replays,omitempty"`
+	// Responses to the reveals of the delayed reveal mode
+	DelayedReveals *ResponseStats `json:"delayedReveals,omitempty"`
+	// Committed entries never revealed because the load stopped first
+	DroppedReveals uint64 `json:"droppedReveals,omitempty"`
+	// EC spent according to the balance, to be compared with the EC spent
+	// by the accepted commits. A gap close to the cost of the accepted
+	// replays means factomd charged them twice.
+	ECSpent         uint64 `json:"ecSpent"`
+	ExpectedECSpent uint64 `json:"expectedEcSpent"`
+	ReplayedCost    uint64 `json:"replayedCost"`
+	// Submit queue metrics
+	Queue *QueueStats `json:"queue,omitempty"`
+	// Scheduled and actual rates of submission
+	TargetEPS   float64 `json:"targetEps,omitempty"`
+	AchievedEPS float64 `json:"achievedEps"`
+	// Throughput of the target chains in the blocks created during the load
+	Blocks *BlockThroughput `json:"blocks,omitempty"`
+	// Precomposed entries signed again with a fresh timestamp
+	Resigned uint64 `json:"resigned,omitempty"`
+	// Time spent composing the entries before the load, excluded from Duration
+	PrecomposeDuration float64 `json:"precomposeDuration,omitempty"`
+	// Latest resource usage of the host
+	Host *hostmetrics.Sample `json:"host,omitempty"`
+}
+
+func (s *Stats) snapshot() StatsSnapshot {
+	var invalid map[InvalidKind]ResponseStats
+	for kind, recorder := range s.invalid {
+		if stats := recorder.snapshot(); stats.Submitted > 0 {
+			if invalid == nil {
+				invalid = make(map[InvalidKind]ResponseStats)
+			}
+			invalid[kind] = stats
+		}
+	}
+
+	var replays *ResponseStats
+	if stats := s.replays.snapshot(); stats.Submitted > 0 {
+		replays = &stats
+	}
+	var delayedReveals *ResponseStats
+	if stats := s.delayedReveals.snapshot(); stats.Submitted > 0 {
+		delayedReveals = &stats
+	}
+	precomposeDuration := time.Duration(atomic.LoadInt64(&s.precomposeDuration))
+	duration := (time.Since(s.start) - precomposeDuration).Seconds()
+	var queue *QueueStats
+	var achievedEPS float64
+	if s.queue != nil {
+		stats := s.queue.stats()
+		queue = &stats
+		if duration > 0 {
+			achievedEPS = float64(stats.Processed) / duration
+		}
+	}
+	var ecSpent uint64
+	if initial, last := atomic.LoadUint64(&s.initialBalance), atomic.LoadUint64(&s.lastBalance); initial > last {
+		ecSpent = initial - last
+	}
+
+	return StatsSnapshot{
+		JobID:              s.jobID,
+		Seed:               s.seed,
+		Duration:           duration,
+		Submitted:          atomic.LoadUint64(&s.submitted),
+		Errors:             atomic.LoadUint64(&s.errors),
+		ErrorMessages:      s.errorMessages.snapshot(),
+		SubmitLatency:      s.submit.distribution(),
+		PropagationLatency: s.propagation.distribution(),
+		Unpropagated:       atomic.LoadUint64(&s.unpropagated),
+		Invalid:            invalid,
+		Replays:            replays,
+		DelayedReveals:     delayedReveals,
+		DroppedReveals:     atomic.LoadUint64(&s.droppedReveals),
+		ECSpent:            ecSpent,
+		ExpectedECSpent:    atomic.LoadUint64(&s.expectedSpend),
+		ReplayedCost:       atomic.LoadUint64(&s.replayedCost),
+		Queue:              queue,
+		TargetEPS:          math.Float64frombits(atomic.LoadUint64(&s.targetEPS)),
+		AchievedEPS:        achievedEPS,
+		Blocks:             s.blocks.snapshot(),
+		Resigned:           atomic.LoadUint64(&s.resigned),
+		PrecomposeDuration: precomposeDuration.Seconds(),
+		Host:               s.host.last(),
+	}
+}
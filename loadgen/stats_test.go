@@ -0,0 +1,25 @@
+package loadgen
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyDistribution(t *testing.T) {
+	require := require.New(t)
+
+	lr := newLatencyRecorder()
+	for i := 1; i <= 100; i++ {
+		lr.record(time.Duration(i) * time.Millisecond)
+	}
+
+	dist := lr.distribution()
+	require.EqualValues(100, dist.Count)
+	require.Equal(50.5, dist.Mean)
+	require.Equal(50.0, dist.P50)
+	require.Equal(90.0, dist.P90)
+	require.Equal(99.0, dist.P99)
+	require.Equal(100.0, dist.Max)
+}
@@ -0,0 +1,179 @@
+package loadgen
+
+import (
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulBernier/chockagent/journal"
+)
+
+const (
+	statsInterval        = 30 * time.Second
+	probePollingInterval = 100 * time.Millisecond
+	probeTimeout         = 2 * time.Minute
+	maxConcurrentProbes  = 50
+	// Share of the entries probed when not set by the load config
+	defaultPropagationSampleRate = 0.1
+)
+
+// submitEntry composes and submits a single entry, accounting for it in the stats.
+func (lg *LoadGenerator) submitEntry(composer *RandomEntryComposer) {
+	rng := composer.submissionRand()
+	defer composer.rngs.Put(rng)
+
+	if lg.invalidTraffic.Ratio > 0 && rng.Float64() < lg.invalidTraffic.Ratio {
+		lg.submitInvalidEntry(composer)
+		return
+	}
+
+	atomic.AddUint64(&lg.stats.submitted, 1)
+
+	commit, reveal, err := lg.nextEntry(composer)
+
+	// This should never happen, it's a hard failure
+	if err != nil {
+		atomic.AddUint64(&lg.stats.errors, 1)
+		log.WithError(err).Error("Fatal: failed to compose entry")
+		return
+	}
+
+	start := time.Now()
+	switch lg.submitConfig.Mode {
+	case ModeCommitOnly, ModeDelayedReveal:
+		err = lg.factomd.CommitEntry(lg.ctx, commit)
+	default:
+		err = lg.factomd.CommitAndRevealEntry(lg.ctx, commit, reveal)
+	}
+	submitted := time.Now()
+	lg.journalSubmission(commit, reveal, submitted.Sub(start), err)
+	// It is expected that API calls will start failing under heavy load
+	if err != nil {
+		atomic.AddUint64(&lg.stats.errors, 1)
+		lg.stats.errorMessages.record(err)
+		log.WithError(err).Warn("Failed to submit entry")
+		composer.Release(commit, reveal)
+		return
+	}
+	lg.stats.submit.record(submitted.Sub(start))
+	lg.stats.accountCommit(commit)
+
+	switch lg.submitConfig.Mode {
+	case ModeCommitOnly:
+	case ModeDelayedReveal:
+		lg.scheduleReveal(reveal)
+	default:
+		lg.ownEntries.add(commit[7:39])
+		if lg.factomd.Probe != nil && rng.Float64() < lg.propagationSampleRate {
+			lg.probePropagation(commit, reveal, submitted)
+		}
+		// Replayed entries are submitted again later
+		lg.captureReplay(commit, reveal, rng)
+	}
+	composer.Release(commit, reveal)
+}
+
+// journalSubmission records the outcome of the submission of an entry.
+func (lg *LoadGenerator) journalSubmission(commit []byte, reveal []byte, latency time.Duration, err error) {
+	if lg.journal == nil {
+		return
+	}
+
+	record := submissionRecord(journal.Submission, commit, reveal, latency)
+	if err != nil {
+		record.Result = journal.ResultError
+		record.Error = err.Error()
+	} else if lg.submitConfig.Mode == ModeCommitOnly || lg.submitConfig.Mode == ModeDelayedReveal {
+		record.Result = journal.ResultCommitted
+	}
+	lg.journal.Write(record)
+}
+
+// journalTraffic records the outcome of the submission of an invalid or replayed entry,
+// whose commit and reveal are accounted for apart.
+func (lg *LoadGenerator) journalTraffic(record journal.Record, commitErr, revealErr error, revealed bool) {
+	switch {
+	case commitErr != nil:
+		record.Result = journal.ResultError
+		record.Error = commitErr.Error()
+	case revealErr != nil:
+		record.Result = journal.ResultError
+		record.Error = revealErr.Error()
+	case !revealed:
+		record.Result = journal.ResultCommitted
+	}
+	lg.journal.Write(record)
+}
+
+func submissionRecord(kind journal.Kind, commit []byte, reveal []byte, latency time.Duration) journal.Record {
+	var timestamp int64
+	for _, b := range commit[1:7] {
+		timestamp = timestamp<<8 | int64(b)
+	}
+	return journal.Record{
+		Kind:      kind,
+		EntryHash: hex.EncodeToString(commit[7:39]),
+		ChainID:   hex.EncodeToString(reveal[1:33]),
+		Size:      len(reveal),
+		ECCost:    commit[commitSignedDataSize-1],
+		Timestamp: timestamp,
+		Latency:   milliseconds(latency),
+		Result:    journal.ResultAccepted,
+	}
+}
+
+// probePropagation measures how long it takes for an entry
+// submitted to the cluster to be acknowledged by the probe node.
+func (lg *LoadGenerator) probePropagation(commit []byte, reveal []byte, submitted time.Time) {
+	if atomic.AddInt64(&lg.probes, 1) > maxConcurrentProbes {
+		atomic.AddInt64(&lg.probes, -1)
+		return
+	}
+
+	entryHash := hex.EncodeToString(commit[7:39])
+	chainID := hex.EncodeToString(reveal[1:33])
+
+	go func() {
+		defer atomic.AddInt64(&lg.probes, -1)
+
+		ticker := time.NewTicker(probePollingInterval)
+		defer ticker.Stop()
+		timeout := time.After(probeTimeout)
+
+		for {
+			select {
+			case <-lg.ctx.Done():
+				return
+			case <-timeout:
+				atomic.AddUint64(&lg.stats.unpropagated, 1)
+				lg.journal.Write(journal.Record{Kind: journal.Confirmation,
+					EntryHash: entryHash, Status: journal.StatusUnconfirmed})
+				return
+			case <-ticker.C:
+				ack, err := lg.factomd.Probe.EntryAck(lg.ctx, entryHash, chainID)
+				if err == nil && ack.IsAcknowledged() {
+					latency := time.Since(submitted)
+					lg.stats.propagation.record(latency)
+					lg.journal.Write(journal.Record{Kind: journal.Confirmation,
+						EntryHash: entryHash, Latency: milliseconds(latency), Status: journal.StatusConfirmed})
+					return
+				}
+			}
+		}
+	}()
+}
+
+// reportStats periodically emits the stats of the load.
+func (lg *LoadGenerator) reportStats() {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lg.ctx.Done():
+			return
+		case <-ticker.C:
+			lg.emit(Event{Type: "load-stats", Payload: lg.stats.snapshot()})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package loadgen
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Number of submit workers when not set by the load config
+	defaultWorkers = 200
+)
+
+// workerPool submits entries with a fixed number of workers
+// fed by a bounded queue. The load schedulers only enqueue work.
+type workerPool struct {
+	workers int
+	// Enqueue time of each pending submission
+	queue    chan time.Time
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	// Number of workers currently submitting
+	busy int64
+	// Submissions picked up by the workers
+	processed uint64
+	// Submissions dropped because the queue was full
+	dropped uint64
+	// Time spent in the queue before being picked up by a worker
+	wait *latencyRecorder
+}
+
+// newWorkerPool starts the workers, each calling work for every queued submission.
+// Work queued after the done channel is closed is discarded.
+func newWorkerPool(workers int, done <-chan struct{}, work func()) *workerPool {
+	p := &workerPool{
+		workers: workers,
+		// A queue as large as the pool bounds the backlog
+		// to about twice the number of workers
+		queue: make(chan time.Time, workers),
+		wait:  newLatencyRecorder(),
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for enqueued := range p.queue {
+				select {
+				case <-done:
+					continue
+				default:
+				}
+
+				p.wait.record(time.Since(enqueued))
+				atomic.AddUint64(&p.processed, 1)
+				atomic.AddInt64(&p.busy, 1)
+				work()
+				atomic.AddInt64(&p.busy, -1)
+			}
+		}()
+	}
+
+	return p
+}
+
+// enqueue queues a submission, waiting for room in the queue.
+// It returns false if done was closed first.
+func (p *workerPool) enqueue(done <-chan struct{}) bool {
+	select {
+	case p.queue <- time.Now():
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// tryEnqueue queues a submission if there is room in the queue.
+func (p *workerPool) tryEnqueue() bool {
+	select {
+	case p.queue <- time.Now():
+		return true
+	default:
+		return false
+	}
+}
+
+// drop accounts for submissions dropped without trying to enqueue them.
+func (p *workerPool) drop(n uint64) {
+	atomic.AddUint64(&p.dropped, n)
+}
+
+// stop closes the queue and waits for the workers to be done with it.
+// It must not be called concurrently with enqueue.
+func (p *workerPool) stop() {
+	p.stopOnce.Do(func() { close(p.queue) })
+	p.wg.Wait()
+}
+
+// QueueStats shows the backpressure of factomd on the load.
+type QueueStats struct {
+	Workers     int    `json:"workers"`
+	BusyWorkers int64  `json:"busyWorkers"`
+	Depth       int    `json:"depth"`
+	Capacity    int    `json:"capacity"`
+	Processed   uint64 `json:"processed"`
+	// Submissions skipped to keep up with the scheduled rate
+	Dropped uint64              `json:"dropped"`
+	Wait    LatencyDistribution `json:"wait"`
+}
+
+func (p *workerPool) stats() QueueStats {
+	return QueueStats{
+		Workers:     p.workers,
+		BusyWorkers: atomic.LoadInt64(&p.busy),
+		Depth:       len(p.queue),
+		Capacity:    cap(p.queue),
+		Processed:   atomic.LoadUint64(&p.processed),
+		Dropped:     atomic.LoadUint64(&p.dropped),
+		Wait:        p.wait.distribution(),
+	}
+}
@@ -0,0 +1,49 @@
+package loadgen
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPoolDropsWhenFull(t *testing.T) {
+	require := require.New(t)
+	block := make(chan struct{})
+	var done int64
+	pool := newWorkerPool(2, nil, func() {
+		<-block
+		atomic.AddInt64(&done, 1)
+	})
+
+	// 2 submissions picked by the workers and 2 queued, at most
+	queued := 0
+	for i := 0; i < 10; i++ {
+		if pool.tryEnqueue() {
+			queued++
+		} else {
+			pool.drop(1)
+		}
+	}
+	require.True(queued >= 2 && queued <= 4)
+	stats := pool.stats()
+	require.EqualValues(10-queued, stats.Dropped)
+	require.Equal(2, stats.Capacity)
+
+	close(block)
+	pool.stop()
+	require.EqualValues(queued, atomic.LoadInt64(&done))
+	require.Zero(pool.stats().BusyWorkers)
+}
+
+func TestWorkerPoolDiscardsOnceDone(t *testing.T) {
+	require := require.New(t)
+	done := make(chan struct{})
+	close(done)
+	var calls int64
+	pool := newWorkerPool(1, done, func() { atomic.AddInt64(&calls, 1) })
+
+	require.True(pool.tryEnqueue())
+	pool.stop()
+	require.Zero(atomic.LoadInt64(&calls))
+}
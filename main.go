@@ -9,16 +9,21 @@ import (
 	"time"
 
 	"github.com/PaulBernier/chockagent/agent"
+	"github.com/PaulBernier/chockagent/factomd"
 )
 
 func main() {
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAudit(os.Args[2:]))
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
 	stopAgent := make(chan struct{})
-	agent := agent.NewAgent(os.Getenv("AGENT_NAME"))
+	agent := agent.NewAgent(os.Getenv("AGENT_NAME"), factomd.NewDefaultCluster())
 	agentDone := agent.Start(stopAgent)
 
 	defer func() {
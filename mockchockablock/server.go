@@ -0,0 +1,240 @@
+// Package mockchockablock implements the coordinator side of the
+// ChockaBlock protocol to test agents end to end.
+package mockchockablock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message sent by an agent.
+type Message struct {
+	Agent     string          `json:"-"`
+	Type      string          `json:"type"`
+	Timestamp int64           `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// Command sent to an agent.
+type Command struct {
+	Command string                 `json:"command"`
+	Params  map[string]interface{} `json:"params"`
+}
+
+type agentConn struct {
+	conn *websocket.Conn
+	// Serializes writes to the connection
+	mu sync.Mutex
+}
+
+// Server is a fake ChockaBlock coordinator, safe for concurrent use.
+type Server struct {
+	// Websocket URL of the server
+	URL string
+
+	httpSrv  *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	agents      map[string]*agentConn
+	connections map[string]int
+	messages    []Message
+}
+
+func NewServer() *Server {
+	srv := new(Server)
+	srv.cond = sync.NewCond(&srv.mu)
+	srv.agents = make(map[string]*agentConn)
+	srv.connections = make(map[string]int)
+	srv.httpSrv = httptest.NewServer(http.HandlerFunc(srv.handle))
+	srv.URL = "ws" + strings.TrimPrefix(srv.httpSrv.URL, "http")
+
+	return srv
+}
+
+func (srv *Server) Close() {
+	srv.mu.Lock()
+	for _, agent := range srv.agents {
+		agent.conn.Close()
+	}
+	srv.mu.Unlock()
+	srv.httpSrv.Close()
+}
+
+func (srv *Server) handle(w http.ResponseWriter, r *http.Request) {
+	name := r.Header.Get("agentname")
+	if name == "" {
+		http.Error(w, "Missing agentname header", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := srv.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	agent := &agentConn{conn: conn}
+
+	srv.mu.Lock()
+	srv.agents[name] = agent
+	srv.connections[name]++
+	srv.cond.Broadcast()
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		if srv.agents[name] == agent {
+			delete(srv.agents, name)
+		}
+		srv.cond.Broadcast()
+		srv.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		msg := Message{Agent: name}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		srv.mu.Lock()
+		srv.messages = append(srv.messages, msg)
+		srv.cond.Broadcast()
+		srv.mu.Unlock()
+	}
+}
+
+// Send pushes a command to a connected agent.
+func (srv *Server) Send(agentName string, cmd Command) error {
+	agent, err := srv.agent(agentName)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	return agent.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Drop abruptly closes the connection of an agent.
+func (srv *Server) Drop(agentName string) error {
+	agent, err := srv.agent(agentName)
+	if err != nil {
+		return err
+	}
+	return agent.conn.Close()
+}
+
+// Disconnect gracefully closes the connection of an agent.
+func (srv *Server) Disconnect(agentName string, reason string) error {
+	agent, err := srv.agent(agentName)
+	if err != nil {
+		return err
+	}
+
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	return agent.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason),
+		time.Now().Add(time.Second))
+}
+
+func (srv *Server) agent(agentName string) (*agentConn, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	agent, ok := srv.agents[agentName]
+	if !ok {
+		return nil, fmt.Errorf("Agent [%s] not connected", agentName)
+	}
+	return agent, nil
+}
+
+// Connections returns the number of times an agent connected.
+func (srv *Server) Connections(agentName string) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.connections[agentName]
+}
+
+// Messages returns all the messages received so far.
+func (srv *Server) Messages() []Message {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]Message(nil), srv.messages...)
+}
+
+// WaitConnections waits for an agent to have connected n times.
+func (srv *Server) WaitConnections(agentName string, n int, timeout time.Duration) error {
+	return srv.wait(timeout, func() bool {
+		_, connected := srv.agents[agentName]
+		return connected && srv.connections[agentName] >= n
+	})
+}
+
+// WaitMessage waits for a message of the given type
+// and returns the first one received.
+func (srv *Server) WaitMessage(msgType string, timeout time.Duration) (Message, error) {
+	var msg Message
+	err := srv.wait(timeout, func() bool {
+		for _, m := range srv.messages {
+			if m.Type == msgType {
+				msg = m
+				return true
+			}
+		}
+		return false
+	})
+	return msg, err
+}
+
+// WaitMessages waits for n messages of a given type from any agent.
+func (srv *Server) WaitMessages(msgType string, n int, timeout time.Duration) ([]Message, error) {
+	var msgs []Message
+	err := srv.wait(timeout, func() bool {
+		msgs = msgs[:0]
+		for _, m := range srv.messages {
+			if m.Type == msgType {
+				msgs = append(msgs, m)
+			}
+		}
+		return len(msgs) >= n
+	})
+	return msgs, err
+}
+
+// wait waits for cond to be true, cond being called with the lock held.
+func (srv *Server) wait(timeout time.Duration, cond func() bool) error {
+	timer := time.AfterFunc(timeout, func() {
+		srv.mu.Lock()
+		srv.cond.Broadcast()
+		srv.mu.Unlock()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(timeout)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out after %s", timeout)
+		}
+		srv.cond.Wait()
+	}
+	return nil
+}
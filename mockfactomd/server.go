@@ -0,0 +1,735 @@
+// Package mockfactomd implements a fake factomd JSON-RPC API
+// sufficient to run the agent without a real node.
+package mockfactomd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdamSLevy/jsonrpc2/v14"
+	"github.com/Factom-Asset-Tokens/factom"
+)
+
+const (
+	entryCommitSize = 136
+	entryHeaderSize = 35
+	maxEntrySize    = 10240
+	// Maximum difference between the timestamp of a commit and the node time
+	commitTimeWindow = time.Hour
+
+	// Node rejected the request
+	ErrorCodeRejected jsonrpc2.ErrorCode = 1
+	// Error injected by the configuration
+	ErrorCodeInjected jsonrpc2.ErrorCode = 2
+	// Requested object not found
+	ErrorCodeNotFound jsonrpc2.ErrorCode = 3
+
+	// Version reported by the properties method
+	Version = "0.0.0-mock"
+)
+
+type Config struct {
+	NetworkID factom.NetworkID
+	// Duration of a simulated factomd minute, a block lasting 10 minutes
+	MinuteDuration time.Duration
+	// Latency added to every request, up to Latency+Jitter
+	Latency time.Duration
+	Jitter  time.Duration
+	// Share of the requests failing with an injected error
+	ErrorRate float64
+	// Initial EC balance of any address
+	ECBalance uint64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		NetworkID:      factom.LocalnetID(),
+		MinuteDuration: 6 * time.Second,
+		ECBalance:      1e9,
+	}
+}
+
+type commit struct {
+	ecAddress string
+	cost      int
+	height    int
+}
+
+type entry struct {
+	chainID []byte
+	height  int
+	// Reveal time in seconds
+	timestamp int64
+}
+
+// Counters of the requests handled by the server.
+type Counters struct {
+	Commits  uint64
+	Reveals  uint64
+	Rejected uint64
+	Injected uint64
+}
+
+// Server is a fake factomd node, safe for concurrent use.
+type Server struct {
+	config Config
+	start  time.Time
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	commits  map[[32]byte]commit
+	entries  map[[32]byte]entry
+	balances map[string]uint64
+	counters Counters
+}
+
+func NewServer(config Config) *Server {
+	srv := new(Server)
+	srv.config = config
+	if srv.config.MinuteDuration <= 0 {
+		srv.config.MinuteDuration = DefaultConfig().MinuteDuration
+	}
+	srv.start = time.Now()
+	srv.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	srv.commits = make(map[[32]byte]commit)
+	srv.entries = make(map[[32]byte]entry)
+	srv.balances = make(map[string]uint64)
+
+	return srv
+}
+
+// Handler returns the handler of the factomd v2 API.
+func (srv *Server) Handler() http.Handler {
+	methods := jsonrpc2.MethodMap{
+		"dblock-by-height":     srv.dblockByHeight,
+		"current-minute":       srv.currentMinute,
+		"commit-entry":         srv.commitEntry,
+		"reveal-entry":         srv.revealEntry,
+		"entry-ack":            srv.entryAck,
+		"entry-credit-balance": srv.entryCreditBalance,
+		"entry":                srv.entry,
+		"chain-head":           srv.chainHead,
+		"entry-block":          srv.entryBlock,
+		"directory-block-head": srv.directoryBlockHead,
+		"directory-block":      srv.directoryBlock,
+		"properties":           srv.properties,
+		"heights":              srv.heights,
+	}
+	for name, method := range methods {
+		methods[name] = srv.withFaults(method)
+	}
+
+	return jsonrpc2.HTTPRequestHandler(methods, nil)
+}
+
+// DebugHandler returns the handler of the factomd debug API.
+// Its results only mimic the shape of the real ones.
+func (srv *Server) DebugHandler() http.Handler {
+	methods := jsonrpc2.MethodMap{
+		"holding-queue":     srv.holdingQueue,
+		"process-list":      srv.processList,
+		"federated-servers": srv.federatedServers,
+		"audit-servers":     srv.auditServers,
+		"network-info":      srv.networkInfo,
+		"summary":           srv.summary,
+		"configuration":     srv.configuration,
+	}
+	for name, method := range methods {
+		methods[name] = srv.withFaults(method)
+	}
+
+	return jsonrpc2.HTTPRequestHandler(methods, nil)
+}
+
+// Mux serves the v2 API on /v2 and the debug API on /debug, like factomd.
+func (srv *Server) Mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/v2", srv.Handler())
+	mux.Handle("/debug", srv.DebugHandler())
+	return mux
+}
+
+func (srv *Server) Counters() Counters {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.counters
+}
+
+// SetBalance sets the EC balance of an address.
+func (srv *Server) SetBalance(ecAddress string, balance uint64) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.balances[ecAddress] = balance
+}
+
+// withFaults adds the configured latency and errors to a method.
+func (srv *Server) withFaults(method jsonrpc2.MethodFunc) jsonrpc2.MethodFunc {
+	return func(ctx context.Context, params json.RawMessage) interface{} {
+		srv.mu.Lock()
+		latency := srv.config.Latency
+		if srv.config.Jitter > 0 {
+			latency += time.Duration(srv.rand.Int63n(int64(srv.config.Jitter)))
+		}
+		injected := srv.rand.Float64() < srv.config.ErrorRate
+		if injected {
+			srv.counters.Injected++
+		}
+		srv.mu.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if injected {
+			return jsonrpc2.NewError(ErrorCodeInjected, "Injected error", nil)
+		}
+
+		return method(ctx, params)
+	}
+}
+
+// heightAndMinute returns the simulated directory block height and minute.
+func (srv *Server) heightAndMinute() (int, int) {
+	minutes := int(time.Since(srv.start) / srv.config.MinuteDuration)
+	return minutes / 10, minutes % 10
+}
+
+func (srv *Server) reject(msg string) jsonrpc2.Error {
+	srv.mu.Lock()
+	srv.counters.Rejected++
+	srv.mu.Unlock()
+	return jsonrpc2.NewError(ErrorCodeRejected, msg, nil)
+}
+
+func (srv *Server) dblockByHeight(ctx context.Context, params json.RawMessage) interface{} {
+	var result struct {
+		DBlock struct {
+			Header struct {
+				NetworkID uint32 `json:"networkid"`
+			} `json:"header"`
+		} `json:"dblock"`
+	}
+	result.DBlock.Header.NetworkID = binary.BigEndian.Uint32(srv.config.NetworkID[:])
+	return result
+}
+
+func (srv *Server) currentMinute(ctx context.Context, params json.RawMessage) interface{} {
+	height, minute := srv.heightAndMinute()
+	return struct {
+		DBHeight      int   `json:"directoryblockheight"`
+		LeaderHeight  int   `json:"leaderheight"`
+		Minute        int   `json:"minute"`
+		CurrentTime   int64 `json:"currenttime"`
+		StallDetected bool  `json:"stalldetected"`
+	}{DBHeight: height, LeaderHeight: height + 1, Minute: minute, CurrentTime: time.Now().UnixNano()}
+}
+
+func (srv *Server) properties(ctx context.Context, params json.RawMessage) interface{} {
+	return struct {
+		FactomdVersion    string `json:"factomdversion"`
+		FactomdAPIVersion string `json:"factomdapiversion"`
+	}{FactomdVersion: Version, FactomdAPIVersion: "2.0"}
+}
+
+func (srv *Server) heights(ctx context.Context, params json.RawMessage) interface{} {
+	height, _ := srv.heightAndMinute()
+	// The node is always synced
+	return struct {
+		DirectoryBlockHeight int `json:"directoryblockheight"`
+		LeaderHeight         int `json:"leaderheight"`
+		EntryBlockHeight     int `json:"entryblockheight"`
+		EntryHeight          int `json:"entryheight"`
+	}{DirectoryBlockHeight: height, LeaderHeight: height + 1, EntryBlockHeight: height, EntryHeight: height}
+}
+
+func (srv *Server) holdingQueue(ctx context.Context, params json.RawMessage) interface{} {
+	return struct {
+		Messages []interface{} `json:"Messages"`
+	}{Messages: []interface{}{}}
+}
+
+func (srv *Server) processList(ctx context.Context, params json.RawMessage) interface{} {
+	height, minute := srv.heightAndMinute()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	pending := 0
+	for _, e := range srv.entries {
+		if e.height >= height {
+			pending++
+		}
+	}
+	return struct {
+		ProcessList string `json:"ProcessList"`
+	}{ProcessList: fmt.Sprintf("===ProcessListStart===\nht: %d minute: %d entries: %d\n===ProcessListEnd===",
+		height+1, minute, pending)}
+}
+
+func (srv *Server) federatedServers(ctx context.Context, params json.RawMessage) interface{} {
+	type server struct {
+		ChainID string `json:"ChainID"`
+		Online  bool   `json:"Online"`
+	}
+	return []server{{ChainID: "38bab1455b7bd7e5efd15c53c777c79d0c988e9210f1da49a99d95b3a6417be9", Online: true}}
+}
+
+func (srv *Server) auditServers(ctx context.Context, params json.RawMessage) interface{} {
+	return []interface{}{}
+}
+
+func (srv *Server) networkInfo(ctx context.Context, params json.RawMessage) interface{} {
+	return struct {
+		NodeName    string `json:"NodeName"`
+		NetworkID   uint32 `json:"NetworkNumber"`
+		NetworkName string `json:"NetworkName"`
+	}{NodeName: "mockfactomd", NetworkID: binary.BigEndian.Uint32(srv.config.NetworkID[:]),
+		NetworkName: srv.config.NetworkID.String()}
+}
+
+func (srv *Server) summary(ctx context.Context, params json.RawMessage) interface{} {
+	height, minute := srv.heightAndMinute()
+	return struct {
+		Summary string `json:"Summary"`
+	}{Summary: fmt.Sprintf("mockfactomd [L___] %d/%d minute %d", height, height+1, minute)}
+}
+
+func (srv *Server) configuration(ctx context.Context, params json.RawMessage) interface{} {
+	return struct {
+		MinuteDuration string  `json:"MinuteDuration"`
+		Latency        string  `json:"Latency"`
+		Jitter         string  `json:"Jitter"`
+		ErrorRate      float64 `json:"ErrorRate"`
+	}{MinuteDuration: srv.config.MinuteDuration.String(), Latency: srv.config.Latency.String(),
+		Jitter: srv.config.Jitter.String(), ErrorRate: srv.config.ErrorRate}
+}
+
+func (srv *Server) commitEntry(ctx context.Context, params json.RawMessage) interface{} {
+	var p struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return jsonrpc2.ErrorInvalidParams(err.Error())
+	}
+	data, err := hex.DecodeString(p.Message)
+	if err != nil || len(data) != entryCommitSize {
+		return jsonrpc2.ErrorInvalidParams("Invalid commit")
+	}
+
+	// Version (1), timestamp (6), entry hash (32), EC cost (1), public key (32), signature (64)
+	signedData := data[:40]
+	publicKey := ed25519.PublicKey(data[40:72])
+	if !ed25519.Verify(publicKey, signedData, data[72:]) {
+		return srv.reject("Invalid commit signature")
+	}
+
+	ts := time.Unix(0, getInt48BE(data[1:7])*int64(time.Millisecond))
+	if ts.Before(time.Now().Add(-commitTimeWindow)) || ts.After(time.Now().Add(commitTimeWindow)) {
+		return srv.reject("Commit timestamp outside of the acceptance window")
+	}
+
+	cost := int(data[39])
+	if cost < 1 || cost > maxEntrySize/1024 {
+		return srv.reject("Invalid commit cost")
+	}
+
+	var entryHash [32]byte
+	copy(entryHash[:], data[7:39])
+	var ecAddress factom.ECAddress
+	copy(ecAddress[:], publicKey)
+	height, _ := srv.heightAndMinute()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if _, ok := srv.commits[entryHash]; ok {
+		srv.counters.Rejected++
+		return jsonrpc2.NewError(ErrorCodeRejected, "Repeated Commit", nil)
+	}
+	balance, ok := srv.balances[ecAddress.String()]
+	if !ok {
+		balance = srv.config.ECBalance
+	}
+	if balance < uint64(cost) {
+		srv.counters.Rejected++
+		return jsonrpc2.NewError(ErrorCodeRejected, "Insufficient EC balance", nil)
+	}
+	srv.balances[ecAddress.String()] = balance - uint64(cost)
+	srv.commits[entryHash] = commit{ecAddress: ecAddress.String(), cost: cost, height: height}
+	srv.counters.Commits++
+
+	txID := sha256.Sum256(signedData)
+	return struct {
+		Message   string `json:"message"`
+		TxID      string `json:"txid"`
+		EntryHash string `json:"entryhash"`
+	}{Message: "Entry Commit Success", TxID: hex.EncodeToString(txID[:]),
+		EntryHash: hex.EncodeToString(entryHash[:])}
+}
+
+func (srv *Server) revealEntry(ctx context.Context, params json.RawMessage) interface{} {
+	var p struct {
+		Entry string `json:"entry"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return jsonrpc2.ErrorInvalidParams(err.Error())
+	}
+	data, err := hex.DecodeString(p.Entry)
+	if err != nil || len(data) < entryHeaderSize {
+		return jsonrpc2.ErrorInvalidParams("Invalid entry")
+	}
+	if len(data)-entryHeaderSize > maxEntrySize {
+		return srv.reject("Entry cannot be larger than 10KB")
+	}
+
+	entryHash := computeEntryHash(data)
+	height, _ := srv.heightAndMinute()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	c, ok := srv.commits[entryHash]
+	if !ok {
+		srv.counters.Rejected++
+		return jsonrpc2.NewError(ErrorCodeRejected, "Entry not committed", nil)
+	}
+	if c.cost < entryCost(len(data)) {
+		srv.counters.Rejected++
+		return jsonrpc2.NewError(ErrorCodeRejected, "Entry commit cost too low", nil)
+	}
+	if _, ok := srv.entries[entryHash]; ok {
+		srv.counters.Rejected++
+		return jsonrpc2.NewError(ErrorCodeRejected, "Entry already revealed", nil)
+	}
+	chainID := data[1:33]
+	srv.entries[entryHash] = entry{chainID: chainID, height: height, timestamp: time.Now().Unix()}
+	srv.counters.Reveals++
+
+	return struct {
+		Message   string `json:"message"`
+		EntryHash string `json:"entryhash"`
+		ChainID   string `json:"chainid"`
+	}{Message: "Entry Reveal Success", EntryHash: hex.EncodeToString(entryHash[:]),
+		ChainID: hex.EncodeToString(chainID)}
+}
+
+func (srv *Server) entryAck(ctx context.Context, params json.RawMessage) interface{} {
+	var p struct {
+		TxID    string `json:"txid"`
+		ChainID string `json:"chainid"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return jsonrpc2.ErrorInvalidParams(err.Error())
+	}
+	hash, err := hex.DecodeString(p.TxID)
+	if err != nil || len(hash) != 32 {
+		return jsonrpc2.ErrorInvalidParams("Invalid txid")
+	}
+
+	var entryHash [32]byte
+	copy(entryHash[:], hash)
+	height, _ := srv.heightAndMinute()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	status := func(blockHeight int, found bool) string {
+		switch {
+		case !found:
+			return "Unknown"
+		case blockHeight < height:
+			return "DBlockConfirmed"
+		default:
+			return "TransactionACK"
+		}
+	}
+	c, committed := srv.commits[entryHash]
+	e, revealed := srv.entries[entryHash]
+	if revealed && p.ChainID != "" && p.ChainID != hex.EncodeToString(e.chainID) {
+		revealed = false
+	}
+
+	type statusResult struct {
+		Status string `json:"status"`
+	}
+	return struct {
+		EntryHash  string       `json:"entryhash"`
+		CommitData statusResult `json:"commitdata"`
+		EntryData  statusResult `json:"entrydata"`
+	}{EntryHash: p.TxID,
+		CommitData: statusResult{Status: status(c.height, committed)},
+		EntryData:  statusResult{Status: status(e.height, revealed)}}
+}
+
+func (srv *Server) entryCreditBalance(ctx context.Context, params json.RawMessage) interface{} {
+	var p struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return jsonrpc2.ErrorInvalidParams(err.Error())
+	}
+	if _, err := factom.NewECAddress(p.Address); err != nil {
+		return jsonrpc2.ErrorInvalidParams("Invalid EC address")
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	balance, ok := srv.balances[p.Address]
+	if !ok {
+		balance = srv.config.ECBalance
+	}
+
+	return struct {
+		Balance uint64 `json:"balance"`
+	}{Balance: balance}
+}
+
+func (srv *Server) entry(ctx context.Context, params json.RawMessage) interface{} {
+	var p struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return jsonrpc2.ErrorInvalidParams(err.Error())
+	}
+	hash, err := hex.DecodeString(p.Hash)
+	if err != nil || len(hash) != 32 {
+		return jsonrpc2.ErrorInvalidParams("Invalid hash")
+	}
+
+	var entryHash [32]byte
+	copy(entryHash[:], hash)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	e, ok := srv.entries[entryHash]
+	if !ok {
+		return jsonrpc2.NewError(ErrorCodeNotFound, "Entry not found", nil)
+	}
+
+	// The content of the entries is not stored
+	return struct {
+		ChainID string   `json:"chainid"`
+		Content string   `json:"content"`
+		ExtIDs  []string `json:"extids"`
+	}{ChainID: hex.EncodeToString(e.chainID), ExtIDs: []string{}}
+}
+
+// entryBlockKeyMR returns the fake KeyMR of the entry block of a chain at a height.
+func entryBlockKeyMR(chainID string, height int) string {
+	keyMR := sha256.Sum256([]byte(fmt.Sprintf("%s-%d", chainID, height)))
+	return hex.EncodeToString(keyMR[:])
+}
+
+type entryBlock struct {
+	chainID   string
+	height    int
+	prevKeyMR string
+	entries   []entryBlockEntry
+}
+
+type entryBlockEntry struct {
+	EntryHash string `json:"entryhash"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// entryBlocks returns the entry blocks of the completed directory blocks, by KeyMR,
+// and the KeyMR of the last entry block of each chain. Must be called with the lock held.
+func (srv *Server) entryBlocks() (map[string]*entryBlock, map[string]string) {
+	current, _ := srv.heightAndMinute()
+	byChainAndHeight := make(map[string]map[int]*entryBlock)
+	for hash, e := range srv.entries {
+		if e.height >= current {
+			continue
+		}
+		chainID := hex.EncodeToString(e.chainID)
+		if byChainAndHeight[chainID] == nil {
+			byChainAndHeight[chainID] = make(map[int]*entryBlock)
+		}
+		eb := byChainAndHeight[chainID][e.height]
+		if eb == nil {
+			// Revealed entries go in the block following the last completed one
+			eb = &entryBlock{chainID: chainID, height: e.height + 1}
+			byChainAndHeight[chainID][e.height] = eb
+		}
+		eb.entries = append(eb.entries, entryBlockEntry{EntryHash: hex.EncodeToString(hash[:]), Timestamp: e.timestamp})
+	}
+
+	blocks := make(map[string]*entryBlock)
+	heads := make(map[string]string)
+	for chainID, byHeight := range byChainAndHeight {
+		heights := make([]int, 0, len(byHeight))
+		for height := range byHeight {
+			heights = append(heights, height)
+		}
+		sort.Ints(heights)
+
+		prevKeyMR := strings.Repeat("0", 64)
+		for _, height := range heights {
+			eb := byHeight[height]
+			sort.Slice(eb.entries, func(i, j int) bool {
+				return eb.entries[i].Timestamp < eb.entries[j].Timestamp ||
+					eb.entries[i].Timestamp == eb.entries[j].Timestamp && eb.entries[i].EntryHash < eb.entries[j].EntryHash
+			})
+			eb.prevKeyMR = prevKeyMR
+			prevKeyMR = entryBlockKeyMR(chainID, eb.height)
+			blocks[prevKeyMR] = eb
+		}
+		heads[chainID] = prevKeyMR
+	}
+
+	return blocks, heads
+}
+
+func (srv *Server) chainHead(ctx context.Context, params json.RawMessage) interface{} {
+	var p struct {
+		ChainID string `json:"chainid"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return jsonrpc2.ErrorInvalidParams(err.Error())
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	_, heads := srv.entryBlocks()
+	head, ok := heads[p.ChainID]
+	if !ok {
+		return jsonrpc2.NewError(ErrorCodeNotFound, "Missing Chain Head", nil)
+	}
+
+	return struct {
+		ChainHead string `json:"chainhead"`
+	}{ChainHead: head}
+}
+
+func (srv *Server) entryBlock(ctx context.Context, params json.RawMessage) interface{} {
+	var p struct {
+		KeyMR string `json:"keymr"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return jsonrpc2.ErrorInvalidParams(err.Error())
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	blocks, _ := srv.entryBlocks()
+	eb, ok := blocks[p.KeyMR]
+	if !ok {
+		return jsonrpc2.NewError(ErrorCodeNotFound, "Block not found", nil)
+	}
+
+	result := struct {
+		Header struct {
+			ChainID   string `json:"chainid"`
+			PrevKeyMR string `json:"prevkeymr"`
+			DBHeight  int    `json:"dbheight"`
+		} `json:"header"`
+		EntryList []entryBlockEntry `json:"entrylist"`
+	}{EntryList: eb.entries}
+	result.Header.ChainID = eb.chainID
+	result.Header.PrevKeyMR = eb.prevKeyMR
+	result.Header.DBHeight = eb.height
+	return result
+}
+
+// directoryBlockKeyMR returns the fake KeyMR of the directory block at a height.
+func directoryBlockKeyMR(height int) string {
+	keyMR := sha256.Sum256([]byte(fmt.Sprintf("dblock-%d", height)))
+	return hex.EncodeToString(keyMR[:])
+}
+
+func (srv *Server) directoryBlockHead(ctx context.Context, params json.RawMessage) interface{} {
+	height, _ := srv.heightAndMinute()
+	return struct {
+		KeyMR string `json:"keymr"`
+	}{KeyMR: directoryBlockKeyMR(height)}
+}
+
+func (srv *Server) directoryBlock(ctx context.Context, params json.RawMessage) interface{} {
+	var p struct {
+		KeyMR string `json:"keymr"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return jsonrpc2.ErrorInvalidParams(err.Error())
+	}
+
+	// Directory blocks are not stored, find the height of the KeyMR
+	current, _ := srv.heightAndMinute()
+	height := -1
+	for h := current; h >= 0; h-- {
+		if directoryBlockKeyMR(h) == p.KeyMR {
+			height = h
+			break
+		}
+	}
+	if height < 0 {
+		return jsonrpc2.NewError(ErrorCodeNotFound, "Block not found", nil)
+	}
+
+	type entryBlockRef struct {
+		ChainID string `json:"chainid"`
+		KeyMR   string `json:"keymr"`
+	}
+	result := struct {
+		Header struct {
+			PrevBlockKeyMR string `json:"prevblockkeymr"`
+			SequenceNumber int    `json:"sequencenumber"`
+			Timestamp      int64  `json:"timestamp"`
+		} `json:"header"`
+		EntryBlockList []entryBlockRef `json:"entryblocklist"`
+	}{EntryBlockList: []entryBlockRef{}}
+	result.Header.SequenceNumber = height
+	result.Header.PrevBlockKeyMR = strings.Repeat("0", 64)
+	if height > 0 {
+		result.Header.PrevBlockKeyMR = directoryBlockKeyMR(height - 1)
+	}
+	// Start time of the block, built while the previous one was the last completed
+	blockDuration := 10 * srv.config.MinuteDuration
+	result.Header.Timestamp = srv.start.Add(time.Duration(height-1) * blockDuration).Unix()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	blocks, _ := srv.entryBlocks()
+	for keyMR, eb := range blocks {
+		if eb.height == height {
+			result.EntryBlockList = append(result.EntryBlockList, entryBlockRef{ChainID: eb.chainID, KeyMR: keyMR})
+		}
+	}
+	sort.Slice(result.EntryBlockList, func(i, j int) bool {
+		return result.EntryBlockList[i].ChainID < result.EntryBlockList[j].ChainID
+	})
+
+	return result
+}
+
+func computeEntryHash(data []byte) [32]byte {
+	sum := sha512.Sum512(data)
+	return sha256.Sum256(bytes.Join([][]byte{sum[:], data}, nil))
+}
+
+func entryCost(size int) int {
+	size -= entryHeaderSize
+	cost := (size + 1023) / 1024
+	if cost < 1 {
+		cost = 1
+	}
+	return cost
+}
+
+func getInt48BE(data []byte) int64 {
+	var x int64
+	for i := 0; i < 6; i++ {
+		x = x<<8 | int64(data[i])
+	}
+	return x
+}
@@ -0,0 +1,91 @@
+package mockfactomd
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Factom-Asset-Tokens/factom"
+	"github.com/PaulBernier/chockagent/common"
+	"github.com/PaulBernier/chockagent/factomd"
+	"github.com/PaulBernier/chockagent/loadgen"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	esAddressStr = "Es3ytEKt6R5jM9juC4ks7EgxQSX8BpRnM4WADtgFoq7j1WgbEEGW"
+	chainIDStr   = "2d98021e3cf71580102224b2fcb4c5c60595e8fdf6fd1b97c6ef63e9fb3ed635"
+)
+
+func newTestServer() (*Server, *factomd.Client, func()) {
+	srv := NewServer(DefaultConfig())
+	httpSrv := httptest.NewServer(srv.Handler())
+	return srv, factomd.NewClient(httpSrv.URL), httpSrv.Close
+}
+
+func compose(t *testing.T, size int) ([]byte, []byte) {
+	esAddress, _ := factom.NewEsAddress(esAddressStr)
+	composer, err := loadgen.NewRandomEntryComposer([]string{chainIDStr}, esAddress,
+		common.IntRange{Min: size, Max: size})
+	require.NoError(t, err)
+	commit, reveal, err := composer.Compose()
+	require.NoError(t, err)
+	return commit, reveal
+}
+
+func TestCommitAndReveal(t *testing.T) {
+	require := require.New(t)
+	srv, cli, stop := newTestServer()
+	defer stop()
+	ctx := context.Background()
+
+	esAddress, _ := factom.NewEsAddress(esAddressStr)
+	ecAddress := esAddress.ECAddress().String()
+	srv.SetBalance(ecAddress, 10)
+
+	commit, reveal := compose(t, 2000)
+	entryHash := hex.EncodeToString(commit[7:39])
+
+	ack, err := cli.EntryAck(ctx, entryHash, chainIDStr)
+	require.NoError(err)
+	require.False(ack.IsAcknowledged())
+
+	require.NoError(cli.CommitAndRevealEntry(ctx, commit, reveal))
+
+	ack, err = cli.EntryAck(ctx, entryHash, chainIDStr)
+	require.NoError(err)
+	require.True(ack.IsAcknowledged())
+
+	balance, err := cli.EntryCreditBalance(ctx, ecAddress)
+	require.NoError(err)
+	require.EqualValues(8, balance)
+
+	// Repeated commit
+	require.Error(cli.CommitEntry(ctx, commit))
+	require.Equal(Counters{Commits: 1, Reveals: 1, Rejected: 1}, srv.Counters())
+}
+
+func TestRejectInvalidEntries(t *testing.T) {
+	require := require.New(t)
+	srv, cli, stop := newTestServer()
+	defer stop()
+	ctx := context.Background()
+
+	// Invalid signature
+	commit, _ := compose(t, 100)
+	commit[len(commit)-1] ^= 0xff
+	require.Error(cli.CommitEntry(ctx, commit))
+
+	// Reveal without commit
+	_, reveal := compose(t, 100)
+	require.Error(cli.RevealEntry(ctx, reveal))
+
+	// Mismatched entry hash
+	commit, reveal = compose(t, 100)
+	reveal[len(reveal)-1] ^= 0xff
+	require.NoError(cli.CommitEntry(ctx, commit))
+	require.Error(cli.RevealEntry(ctx, reveal))
+
+	require.Equal(Counters{Commits: 1, Rejected: 3}, srv.Counters())
+}
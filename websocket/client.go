@@ -36,8 +36,12 @@ func exponentialBackOff() *backoff.ExponentialBackOff {
 
 type Client struct {
 	Endpoint string
+	// Additional headers sent on connection
+	Header http.Header
 
 	Disconnected chan bool
+	// Returns the first message written on each connection, if set
+	Hello func() []byte
 
 	Send    chan []byte
 	Receive chan []byte
@@ -63,7 +67,11 @@ func init() {
 func NewClient() (cli *Client) {
 	cli = new(Client)
 	cli.Endpoint = chockablockURL
-	cli.Disconnected = make(chan bool)
+	cli.Header = http.Header{}
+	// Buffered so that a disconnection can be notified while the
+	// receiver is blocked sending a message, which only completes
+	// once reconnected
+	cli.Disconnected = make(chan bool, 1)
 
 	cli.Receive = make(chan []byte)
 	cli.Send = make(chan []byte)
@@ -76,8 +84,9 @@ func (cli *Client) Start(agentName string, stop <-chan struct{}) <-chan struct{}
 
 	go func() {
 		defer func() {
+			// Send is not closed as it is written by the client user,
+			// which can rely on the returned done channel instead
 			close(cli.Receive)
-			close(cli.Send)
 			close(cli.Disconnected)
 			close(done)
 		}()
@@ -91,7 +100,11 @@ func (cli *Client) Start(agentName string, stop <-chan struct{}) <-chan struct{}
 			select {
 			case err := <-doneReading:
 				close(stopWrite)
-				cli.Disconnected <- true
+				select {
+				case cli.Disconnected <- true:
+				default:
+					// Previous disconnection not processed yet
+				}
 				conn.Close()
 
 				if err != nil {
@@ -138,6 +151,9 @@ func (cli *Client) connect(agentName string, stop <-chan struct{}) (conn *websoc
 	header := http.Header{
 		"agentname": []string{agentName},
 	}
+	for k, v := range cli.Header {
+		header[k] = v
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	retryStrategy := exponentialBackOff()
@@ -177,6 +193,13 @@ func (cli *Client) connect(agentName string, stop <-chan struct{}) (conn *websoc
 		if resp == nil {
 			return errors.New("Empty response")
 		}
+		if cli.Hello != nil {
+			// Written before the pumps start so that it comes first
+			if err := c.WriteMessage(websocket.BinaryMessage, cli.Hello()); err != nil {
+				c.Close()
+				return err
+			}
+		}
 
 		conn = c
 